@@ -1,15 +1,32 @@
 package env
 
 import (
+	"context"
+	"database/sql"
 	"encoding"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	imagecolor "image/color"
+	"log/slog"
+	"math"
+	"math/big"
+	"net"
+	"net/mail"
 	"net/url"
 	"os"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 var (
@@ -23,6 +40,13 @@ var (
 	// OnEnvVarSet is an optional convenience callback, such as for logging purposes.
 	// If not nil, it's called after successfully setting the given field from the given value.
 	OnEnvVarSet func(reflect.StructField, string)
+	// OnDeprecatedKey is an optional callback invoked when a field's value was
+	// read from the key named in its envDeprecated tag rather than its env tag.
+	OnDeprecatedKey func(field reflect.StructField, oldKey, newKey string)
+	// OnDeprecatedField is an optional callback invoked with the message of
+	// a field's envDeprecatedField tag whenever its env var is set, before
+	// the value is parsed.
+	OnDeprecatedField func(field reflect.StructField, key, message string)
 	// Friendly names for reflect types
 	sliceOfInts      = reflect.TypeOf([]int(nil))
 	sliceOfInt64s    = reflect.TypeOf([]int64(nil))
@@ -33,14 +57,79 @@ var (
 	sliceOfFloat64s  = reflect.TypeOf([]float64(nil))
 	sliceOfDurations = reflect.TypeOf([]time.Duration(nil))
 	sliceOfURLs      = reflect.TypeOf([]url.URL(nil))
+	sliceOfAddresses = reflect.TypeOf([]mail.Address(nil))
+	sliceOfIPNets    = reflect.TypeOf([]net.IPNet(nil))
+	sliceOfTimes     = reflect.TypeOf([]time.Time(nil))
+	// Interfaces checked for when validating field types
+	flagValueType       = reflect.TypeOf((*flag.Value)(nil)).Elem()
+	typedValueType      = reflect.TypeOf((*typedValue)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	scannerType         = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
 )
 
+// typedValue is the part of the spf13/pflag Value interface that parsing
+// needs, so such values work without this package importing pflag.
+type typedValue interface {
+	Set(string) error
+	Type() string
+}
+
+// ParseError is returned when a value cannot be converted to the type of the
+// field it is assigned to.
+type ParseError struct {
+	// Key is the env var the value was read from, if known.
+	Key string
+	// Field is the name of the struct field.
+	Field string
+	// Type is the type of the struct field.
+	Type reflect.Type
+	// Value is the value that failed to parse.
+	Value string
+	// Err is the underlying parse error.
+	Err error
+}
+
+func newParseError(refType reflect.StructField, value string, err error) *ParseError {
+	return &ParseError{Field: refType.Name, Type: refType.Type, Value: value, Err: err}
+}
+
+func (e *ParseError) Error() string {
+	msg := fmt.Sprintf("unable to parse %q into field %s of type %s: %v", e.Value, e.Field, e.Type, e.Err)
+	if e.Key != "" {
+		msg = fmt.Sprintf("env var %s: %s", e.Key, msg)
+	}
+	return msg
+}
+
+// Unwrap returns the underlying parse error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
 // CustomParsers is a friendly name for the type that `ParseWithFuncs()` accepts
 type CustomParsers map[reflect.Type]ParserFunc
 
+// EnumRegistry maps an enum type to the values of that type keyed by name.
+// Fields whose type is registered accept only the registered names.
+type EnumRegistry map[reflect.Type]map[string]interface{}
+
+// FuncRegistry maps a func type to the functions of that type keyed by name.
+// A field of a registered func type is set to the function named by its value.
+type FuncRegistry map[reflect.Type]map[string]interface{}
+
 // ParserFunc defines the signature of a function that can be used within `CustomParsers`
 type ParserFunc func(v string) (interface{}, error)
 
+// SecretResolver fetches secrets from a secret store, for fields tagged
+// envSecretRef.
+type SecretResolver interface {
+	Resolve(ref string) (string, error)
+}
+
+// ContextParserFunc is a ParserFunc that takes a context, for parsers that
+// call out to remote services.
+type ContextParserFunc func(ctx context.Context, v string) (interface{}, error)
+
 // Parse parses a struct containing `env` tags and loads its values from
 // environment variables.
 func Parse(v interface{}) error {
@@ -62,6 +151,293 @@ func ParseWithFuncs(v interface{}, funcMap CustomParsers) error {
 // ParseWithPrefixFuncs is the same as `ParseWithPrefix` except it also allows the user to pass
 // in custom parsers.
 func ParseWithPrefixFuncs(v interface{}, prefix string, funcMap CustomParsers) error {
+	return ParseWithOptions(v, Options{Prefix: prefix, Parsers: funcMap})
+}
+
+// Options holds the settings used by `ParseWithOptions`.
+type Options struct {
+	// Prefix is prepended to the name of every env var looked up.  Each
+	// ${VAR} in it is replaced by the value of VAR first.
+	Prefix string
+	// Parsers are custom parsers keyed by the type they produce.
+	Parsers CustomParsers
+	// ContextParsers are custom parsers keyed by the type they produce that
+	// are called with Context, limited by the envTimeout tag of the field if
+	// it has one.  Parsers take precedence for the same type.
+	ContextParsers map[reflect.Type]ContextParserFunc
+	// SecretResolver supplies the values of fields tagged envSecretRef,
+	// which are not read from the environment.
+	SecretResolver SecretResolver
+	// Context is passed to ContextParsers.  It defaults to
+	// context.Background().
+	Context context.Context
+	// Strict checks that every tagged field has a supported type before
+	// anything is parsed, so an unsupported field is reported even when its
+	// env var is unset.
+	Strict bool
+	// Resolvers are keyed by URI scheme.  A value such as secret://vault/path
+	// is handed to the resolver registered for "secret" and replaced by its
+	// result before being parsed.
+	Resolvers map[string]func(uri string) (string, error)
+	// Defaults are fallback values keyed by env var name (including any
+	// prefix), for example loaded from a base config file.  They are used
+	// when the env var is unset and, unless DefaultsOverrideTags is set,
+	// only when the field has no envDefault tag.  Like envDefault they do not
+	// satisfy a required tag.
+	Defaults map[string]string
+	// DefaultsOverrideTags gives Defaults precedence over envDefault tags.
+	DefaultsOverrideTags bool
+	// Enums holds the named values of enum types.
+	Enums EnumRegistry
+	// Funcs holds the named functions that func-typed fields can select.
+	Funcs FuncRegistry
+	// NormalizeKey, when set, is applied to both the computed key and the
+	// names in the environment before they are compared, for example
+	// strings.ToUpper to match env vars exported in a different case.
+	NormalizeKey func(string) string
+	// KeyRewrite, when set, maps every computed key to the key that is
+	// looked up instead, for example to read a new naming scheme from legacy
+	// variables.  Defaults and overrides still use the computed key.
+	KeyRewrite func(key string) string
+	// Sources are consulted in order before the environment, so values can
+	// be layered from flags or maps.  Defaults apply only when no source and
+	// no env var has the key.
+	Sources []Source
+	// OnUnusedParser, when set, is called after parsing with the type of
+	// every entry in Parsers that no field of the struct could use, which
+	// points at registrations left behind by a refactoring.
+	OnUnusedParser func(t reflect.Type)
+	// OnValidationError, when set, is called for every value that parsed
+	// but was then rejected, such as a name missing from an enum or an error
+	// from a Validate method, before the error is added to those returned.
+	// Values that fail to parse at all are not reported.
+	OnValidationError func(field reflect.StructField, value string, err error)
+	// KeyPattern, when set, gives every untagged field of a parsable type a
+	// key, with {FIELD} replaced by the field name in SCREAMING_SNAKE_CASE.
+	// For example "APP_{FIELD}" reads MaxConns from APP_MAX_CONNS.
+	KeyPattern string
+	// TemplatePasses, when above zero, lets envTemplate defaults refer to
+	// other templated defaults in any order.  They are expanded over up to
+	// this many passes until all are resolved, and references that form a
+	// cycle are an error.
+	TemplatePasses int
+	// PrefixFallback makes a field whose prefixed key is unset try shorter
+	// prefixes in turn, dropping one leading segment of the prefix at a time
+	// until none is left.  With the prefix SERVICE_DB_, a field tagged HOST
+	// reads SERVICE_DB_HOST, then DB_HOST, then HOST.
+	PrefixFallback bool
+	// PrefixDelimiter separates the segments of a prefix for PrefixFallback.
+	// It defaults to "_".
+	PrefixDelimiter string
+	// TreatBlankAsUnset makes a variable whose value is empty or only
+	// whitespace count as unset, so its default applies and a required
+	// field reports it as missing.
+	TreatBlankAsUnset bool
+	// Group, when set, restricts parsing to the fields whose envGroup tag
+	// matches, and to all the fields of a struct field whose tag matches.
+	// Other fields are skipped entirely.
+	Group string
+
+	// plan collects the assignments made by Plan instead of applying them
+	plan *[]PlannedSet
+	// allocating holds the struct types whose nil pointers are being filled
+	allocating []reflect.Type
+	// owner is the struct whose fields are being set, for envParser methods
+	owner reflect.Value
+	// overrides restricts parsing to these keys and values, for ApplyOverrides
+	overrides map[string]string
+	// resolved holds the raw value of every key parsed so far, for envTemplate
+	resolved map[string]string
+	// provenance records where the value of every key came from, for
+	// ParseWithProvenance
+	provenance map[string]Provenance
+	// quiet suppresses OnDeprecatedKey and OnDeprecatedField
+	quiet bool
+}
+
+// ParseWithOptions parses a struct containing `env` tags and loads its values from
+// environment variables, as configured by opts.
+func ParseWithOptions(v interface{}, opts Options) error {
+	opts.Prefix = expandPrefix(opts.Prefix, opts)
+	if opts.OnUnusedParser != nil {
+		defer reportUnusedParsers(v, opts)
+	}
+	if opts.Strict {
+		ptrRef := reflect.ValueOf(v)
+		if ptrRef.Kind() == reflect.Ptr && ptrRef.Elem().Kind() == reflect.Struct {
+			if err := checkTypes(ptrRef.Elem().Type(), opts); err != nil {
+				return err
+			}
+		}
+	}
+	return parse(v, opts.Prefix, opts)
+}
+
+// MustParse is the same as `Parse` except it panics if any field fails to parse.
+func MustParse(v interface{}) {
+	MustParseWithOptions(v, Options{})
+}
+
+// MustParseWithOptions is the same as `ParseWithOptions` except it panics if
+// any field fails to parse.
+func MustParseWithOptions(v interface{}, opts Options) {
+	if err := ParseWithOptions(v, opts); err != nil {
+		panic(fmt.Sprintf("unable to parse environment: %v", err))
+	}
+}
+
+// PlannedSet describes an assignment that Parse would make.
+type PlannedSet struct {
+	// Field is the name of the struct field.
+	Field string
+	// Key is the env var the value was read from.
+	Key string
+	// Raw is the value as read from the environment or the field's default.
+	Raw string
+	// Value is the parsed value that would be assigned to the field.
+	Value interface{}
+}
+
+// Origin is the kind of place a field's value came from.
+type Origin string
+
+const (
+	// OriginSource is one of Options.Sources, such as a MapSource of flags
+	// or of values read from a file.
+	OriginSource Origin = "source"
+	// OriginEnv is the process environment.
+	OriginEnv Origin = "env"
+	// OriginDefault is the envDefault tag or Options.Defaults.
+	OriginDefault Origin = "default"
+	// OriginSecret is Options.SecretResolver, for fields tagged envSecretRef.
+	OriginSecret Origin = "secret"
+)
+
+// Provenance describes where ParseWithProvenance found the value of a field.
+type Provenance struct {
+	// Origin is the kind of place the value came from.
+	Origin Origin
+	// Source is the index in Options.Sources of the source that had the
+	// value, or -1 unless Origin is OriginSource.
+	Source int
+	// Key is the raw key the value was read under.  It differs from the
+	// field's key for an envDeprecated key, a shorter prefix found through
+	// PrefixFallback or a key matched after KeyRewrite or NormalizeKey, and
+	// is the reference for OriginSecret.
+	Key string
+	// Resolver is the scheme of the entry of Options.Resolvers the value was
+	// passed through, or "" if it was used as is.
+	Resolver string
+}
+
+// ApplyOverrides parses the values in overrides, keyed by env var name, into
+// an already populated struct.  Only the fields reading those keys are
+// touched; the environment and defaults are not consulted.  A key that no
+// field reads is an error.
+func ApplyOverrides(v interface{}, overrides map[string]string, opts Options) error {
+	known := map[string]bool{}
+	for _, key := range Keys(v, opts) {
+		known[key] = true
+	}
+	var unknown []string
+	for key := range overrides {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown override keys: %s", strings.Join(unknown, ", "))
+	}
+	if overrides == nil {
+		overrides = map[string]string{}
+	}
+	opts.overrides = overrides
+	return ParseWithOptions(v, opts)
+}
+
+// Plan reports the assignments ParseWithOptions would make to v without
+// modifying it, so a configuration change can be previewed.  A preview does
+// not call OnDeprecatedKey or OnDeprecatedField.
+func Plan(v interface{}, opts Options) ([]PlannedSet, error) {
+	plan := []PlannedSet{}
+	opts.plan = &plan
+	opts.quiet = true
+	if err := ParseWithOptions(v, opts); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// ParseWithProvenance is the same as ParseWithOptions but also reports where
+// the value of every field came from, keyed by the field's path from the
+// top-level struct, such as DB.Host.  Fields left unset are not listed.  An
+// envLazy field is listed with where its value came from at parse time.
+func ParseWithProvenance(v interface{}, opts Options) (map[string]Provenance, error) {
+	opts.provenance = map[string]Provenance{}
+	if err := ParseWithOptions(v, opts); err != nil {
+		return nil, err
+	}
+	provenance := map[string]Provenance{}
+	walkKeys(v, opts, func(_ reflect.Type, field reflect.StructField, prefix, path string) {
+		if origin, ok := opts.provenance[prefix+field.Tag.Get("env")]; ok {
+			provenance[path] = origin
+		}
+	})
+	return provenance, nil
+}
+
+// patternField gives an untagged field the env tag built from
+// Options.KeyPattern, provided its type can be parsed.  Other untagged fields,
+// such as nested structs, are left as they are.
+func patternField(field reflect.StructField, opts Options) reflect.StructField {
+	if opts.KeyPattern == "" || field.PkgPath != "" {
+		return field
+	}
+	if _, hasTag := field.Tag.Lookup("env"); hasTag || !isSupportedType(field.Type, field.Tag, opts) {
+		return field
+	}
+	key := strings.Replace(opts.KeyPattern, "{FIELD}", screamingSnake(field.Name), -1)
+	field.Tag = reflect.StructTag("env:" + strconv.Quote(key) + " " + string(field.Tag))
+	return field
+}
+
+// screamingSnake converts a Go field name such as MaxConns or HTTPPort into
+// MAX_CONNS or HTTP_PORT.
+func screamingSnake(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// expandPrefix replaces each ${VAR} in a prefix with the value of VAR, so
+// the effective prefix can be chosen by the environment at parse time.
+func expandPrefix(prefix string, opts Options) string {
+	for {
+		start := strings.Index(prefix, "${")
+		if start < 0 {
+			return prefix
+		}
+		end := strings.Index(prefix[start:], "}")
+		if end < 0 {
+			return prefix
+		}
+		value, _ := lookupEnv(prefix[start+2:start+end], opts)
+		prefix = prefix[:start] + value + prefix[start+end+1:]
+	}
+}
+
+func parse(v interface{}, prefix string, opts Options) error {
 	ptrRef := reflect.ValueOf(v)
 	if ptrRef.Kind() != reflect.Ptr {
 		return ErrNotAStructPtr
@@ -70,241 +446,2363 @@ func ParseWithPrefixFuncs(v interface{}, prefix string, funcMap CustomParsers) e
 	if ref.Kind() != reflect.Struct {
 		return ErrNotAStructPtr
 	}
-	return doParse(ref, prefix, funcMap)
+	opts.resolved = map[string]string{}
+	if err := doParse(ref, prefix, opts); err != nil {
+		return err
+	}
+	// the whole struct is validated only once every field has parsed, and
+	// not when planning since the struct itself is left unchanged
+	if v, ok := v.(validator); ok && opts.plan == nil {
+		return v.Validate()
+	}
+	return nil
 }
 
-func doParse(ref reflect.Value, prefix string, funcMap CustomParsers) error {
+func doParse(ref reflect.Value, prefix string, opts Options) error {
 	refType := ref.Type()
-	var errorList []string
+	errs := &parseErrors{}
+	opts.owner = ref
 
-	for i := 0; i < refType.NumField(); i++ {
+	order, templated := parseOrder(refType)
+	for n, i := range order {
+		if n == len(order)-templated && opts.TemplatePasses > 0 {
+			if err := resolveTemplates(refType, order[n:], prefix, opts); err != nil {
+				errs.add(err)
+			}
+		}
 		refField := ref.Field(i)
-		if reflect.Ptr == refField.Kind() && !refField.IsNil() && refField.CanSet() {
-			err := ParseWithPrefixFuncs(refField.Interface(), prefix, funcMap)
-			if nil != err {
-				return err
+		refTypeField := patternField(refType.Field(i), opts)
+		fieldPrefix := prefix + refTypeField.Tag.Get("envPrefix")
+		// each field may clear the group for the structs below it
+		opts := opts
+		var skip bool
+		if opts.Group, skip = fieldGroup(refTypeField, opts.Group); skip {
+			continue
+		}
+		if refTypeField.PkgPath != "" {
+			// Unexported fields can't be set, but the exported fields of an
+			// embedded struct of unexported type still can.
+			if !refTypeField.Anonymous {
+				continue
+			}
+			if reflect.Ptr == refField.Kind() && !refField.IsNil() {
+				refField = refField.Elem()
+			}
+			if reflect.Struct == refField.Kind() {
+				if err := doParse(refField, fieldPrefix, opts); err != nil {
+					errs.add(err)
+				}
+			}
+			continue
+		}
+		_, hasTag := refTypeField.Tag.Lookup("env")
+		if reflect.Ptr == refField.Kind() && !refField.IsNil() && (!hasTag || reflect.Struct == refField.Elem().Kind() && !isSupportedType(refField.Type(), refTypeField.Tag, opts)) {
+			// tagged pointers that set can handle, such as *bool or
+			// *time.Location, are set below even when already allocated
+			if reflect.Struct != refField.Elem().Kind() {
+				errs.add(ErrNotAStructPtr)
+			} else if err := doParse(refField.Elem(), fieldPrefix, opts); err != nil {
+				errs.add(err)
+			}
+			continue
+		}
+		if !hasTag && reflect.Ptr == refField.Kind() && reflect.Struct == refField.Type().Elem().Kind() {
+			// A nil struct pointer is only allocated when one of its
+			// variables is set, so empty config leaves it nil.  Types already
+			// being allocated further up are skipped so recursive types end.
+			elemType := refField.Type().Elem()
+			if hasEnv(elemType, fieldPrefix, opts) && elemType != refType && !containsType(opts.allocating, elemType) {
+				nested := opts
+				nested.allocating = append(append([]reflect.Type{}, opts.allocating...), refType)
+				ptr := reflect.New(elemType)
+				if err := doParse(ptr.Elem(), fieldPrefix, nested); err != nil {
+					errs.add(err)
+				} else if opts.plan == nil {
+					refField.Set(ptr)
+				}
+			}
+			continue
+		}
+		target := refField
+		if opts.plan != nil {
+			// when planning, values are parsed into a scratch copy only
+			target = reflect.New(refField.Type()).Elem()
+		}
+		key := fieldPrefix + refTypeField.Tag.Get("env")
+		if _, ok := opts.overrides[key]; opts.overrides != nil && hasTag && !ok {
+			continue
+		}
+		if reflect.Slice == refField.Kind() && strings.ToLower(refTypeField.Tag.Get("envIndexed")) == "true" {
+			if err := handleIndexedSlice(target, key, opts); err != nil {
+				errs.add(err)
+			}
+			continue
+		}
+		if strings.ToLower(refTypeField.Tag.Get("envLazy")) == "true" {
+			if err := handleLazy(target, refTypeField, prefix, opts); err != nil {
+				errs.add(err)
 			}
 			continue
 		}
-		refTypeField := refType.Field(i)
-		value, err := get(refTypeField, prefix)
+		value, err := get(refTypeField, prefix, opts)
 		if err != nil {
-			errorList = append(errorList, err.Error())
+			errs.add(err)
 			continue
 		}
+		if hasTag {
+			opts.resolved[key] = value
+		}
 		if value == "" {
 			if reflect.Struct == refField.Kind() {
-				if err := doParse(refField, prefix, funcMap); err != nil {
-					errorList = append(errorList, err.Error())
+				if err := doParse(refField, fieldPrefix, opts); err != nil {
+					errs.add(err)
 				}
 			}
 			continue
 		}
-		if err := set(refField, refTypeField, value, funcMap); err != nil {
-			errorList = append(errorList, err.Error())
+		if err := set(target, refTypeField, value, opts); err != nil {
+			if parseErr, ok := err.(*ParseError); ok {
+				parseErr.Key = key
+			}
+			reportValidationError(refTypeField, value, err, opts)
+			errs.add(err)
+			continue
+		}
+		if err := validateField(target, refTypeField, key); err != nil {
+			reportValidationError(refTypeField, value, err, opts)
+			errs.add(err)
+			continue
+		}
+		if opts.plan != nil {
+			*opts.plan = append(*opts.plan, PlannedSet{
+				Field: refTypeField.Name,
+				Key:   key,
+				Raw:   value,
+				Value: target.Interface(),
+			})
 			continue
 		}
 		if OnEnvVarSet != nil {
 			OnEnvVarSet(refTypeField, value)
 		}
 	}
-	if len(errorList) == 0 {
-		return nil
+	return errs.err()
+}
+
+// fieldGroup applies Options.Group to a field.  It reports whether the field
+// is skipped and returns the group that the fields of a nested struct are
+// filtered by, which is none once the struct field itself matches.  Nested
+// structs without an envGroup tag are always visited.
+func fieldGroup(field reflect.StructField, group string) (string, bool) {
+	if group == "" {
+		return "", false
+	}
+	if fieldGroup, ok := field.Tag.Lookup("envGroup"); ok {
+		return "", fieldGroup != group
+	}
+	fieldType := field.Type
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	_, hasTag := field.Tag.Lookup("env")
+	return group, hasTag || fieldType.Kind() != reflect.Struct
+}
+
+// handleLazy sets a func() string field tagged envLazy to a function that
+// reads the field with get again on every call, so every tag applies as for a
+// string field.  The value is also read once at parse time, which reports a
+// missing required variable or a failing resolver there.  A call that fails
+// later returns "".
+func handleLazy(field reflect.Value, refType reflect.StructField, prefix string, opts Options) error {
+	if field.Type() != reflect.TypeOf((func() string)(nil)) {
+		return fmt.Errorf("field %s: envLazy requires a func() string: %w", refType.Name, ErrUnsupportedType)
+	}
+	value, err := get(refType, prefix, opts)
+	if err != nil {
+		return err
+	}
+	if OnEnvVarSet != nil && opts.plan == nil && value != "" {
+		OnEnvVarSet(refType, value)
+	}
+	// the function outlives ApplyOverrides, so it reads the real sources, and
+	// only the first read is recorded or reported as deprecated
+	opts.overrides = nil
+	opts.provenance = nil
+	opts.quiet = true
+	field.Set(reflect.ValueOf(func() string {
+		value, _ := get(refType, prefix, opts)
+		return value
+	}))
+	return nil
+}
+
+// parseOrder returns the indexes of the fields of t in declaration order,
+// with the fields tagged envTemplate moved to the end so that their defaults
+// can refer to the values of all the other fields, and how many those are.
+func parseOrder(t reflect.Type) ([]int, int) {
+	order := make([]int, 0, t.NumField())
+	var templated []int
+	for i := 0; i < t.NumField(); i++ {
+		if strings.ToLower(t.Field(i).Tag.Get("envTemplate")) == "true" {
+			templated = append(templated, i)
+		} else {
+			order = append(order, i)
+		}
+	}
+	return append(order, templated...), len(templated)
+}
+
+// resolveTemplates expands the envTemplate defaults of the given fields of t
+// whose env vars are unset, for Options.TemplatePasses.  Each pass expands
+// the defaults whose references are all resolved, so chains of templated
+// defaults resolve in any order.  The results are stored in opts.resolved,
+// where get picks them up.
+func resolveTemplates(t reflect.Type, fields []int, prefix string, opts Options) error {
+	pending := map[string]string{}
+	prefixes := map[string]string{}
+	for _, i := range fields {
+		field := patternField(t.Field(i), opts)
+		fieldPrefix := prefix + field.Tag.Get("envPrefix")
+		key := fieldPrefix + field.Tag.Get("env")
+		if _, found := lookupEnv(key, opts); !found {
+			pending[key] = defaultValue(field, key, opts)
+			prefixes[key] = fieldPrefix
+		}
+	}
+	for pass := 0; len(pending) > 0; pass++ {
+		if pass == opts.TemplatePasses {
+			return fmt.Errorf("templated defaults not resolved after %d passes: %s", pass, strings.Join(sortedKeys(pending), ", "))
+		}
+		var ready []string
+		for _, key := range sortedKeys(pending) {
+			blocked := false
+			os.Expand(pending[key], func(name string) string {
+				if _, ok := pending[prefixes[key]+name]; ok {
+					blocked = true
+				}
+				return ""
+			})
+			if !blocked {
+				ready = append(ready, key)
+			}
+		}
+		if len(ready) == 0 {
+			return fmt.Errorf("templated defaults form a cycle: %s", strings.Join(sortedKeys(pending), ", "))
+		}
+		for _, key := range ready {
+			opts.resolved[key] = expandTemplate(pending[key], prefixes[key], opts)
+		}
+		for _, key := range ready {
+			delete(pending, key)
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// validator is implemented by types that check their own value.
+type validator interface {
+	Validate() error
+}
+
+// validateField calls the Validate method of a field that has just been set,
+// when its type or a pointer to it implements one.
+func validateField(field reflect.Value, refType reflect.StructField, key string) error {
+	if field.Kind() != reflect.Ptr && field.CanAddr() {
+		field = field.Addr()
+	}
+	if field.Kind() == reflect.Ptr && field.IsNil() {
+		return nil
+	}
+	v, ok := field.Interface().(validator)
+	if !ok {
+		return nil
+	}
+	if err := v.Validate(); err != nil {
+		return &validationError{fmt.Errorf("env var %s: invalid value for field %s: %v", key, refType.Name, err)}
+	}
+	return nil
+}
+
+// validationError marks an error for a value that parsed but was rejected,
+// to tell it apart from a parse error for Options.OnValidationError.
+type validationError struct {
+	error
+}
+
+// Unwrap returns the underlying validation error.
+func (e *validationError) Unwrap() error {
+	return e.error
+}
+
+// reportValidationError passes err on to Options.OnValidationError when it
+// is a validation error.
+func reportValidationError(field reflect.StructField, value string, err error, opts Options) {
+	var vErr *validationError
+	if opts.OnValidationError != nil && errors.As(err, &vErr) {
+		opts.OnValidationError(field, value, vErr.error)
+	}
+}
+
+// missingError reports a required env var that is not set.
+type missingError struct {
+	key string
+}
+
+func (e *missingError) Error() string {
+	return fmt.Sprintf("env var %s was missing and is required", e.key)
+}
+
+// parseErrors collects the errors found while parsing a struct, including
+// its nested structs.  Missing required vars are grouped into a single entry.
+type parseErrors struct {
+	missing []*missingError
+	errs    []error
+}
+
+func (e *parseErrors) add(err error) {
+	switch err := err.(type) {
+	case *parseErrors:
+		e.missing = append(e.missing, err.missing...)
+		e.errs = append(e.errs, err.errs...)
+	case *missingError:
+		e.missing = append(e.missing, err)
+	default:
+		e.errs = append(e.errs, err)
+	}
+}
+
+func (e *parseErrors) err() error {
+	switch {
+	case len(e.missing) == 0 && len(e.errs) == 0:
+		return nil
+	case len(e.missing) == 0 && len(e.errs) == 1:
+		// a lone error is returned as is, so it compares equal to sentinels
+		// such as ErrUnsupportedType
+		return e.errs[0]
+	}
+	return e
+}
+
+func (e *parseErrors) Error() string {
+	var list []string
+	switch len(e.missing) {
+	case 0:
+	case 1:
+		list = append(list, "missing required env var: "+e.missing[0].key)
+	default:
+		keys := make([]string, len(e.missing))
+		for i, missing := range e.missing {
+			keys[i] = missing.key
+		}
+		list = append(list, "missing required env vars: "+strings.Join(keys, ", "))
+	}
+	for _, err := range e.errs {
+		list = append(list, err.Error())
+	}
+	return strings.Join(list, ". ")
+}
+
+// Unwrap returns the collected errors, the missing required vars first, so
+// that errors.As finds a *ParseError among them.
+func (e *parseErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e.missing)+len(e.errs))
+	for _, missing := range e.missing {
+		errs = append(errs, missing)
+	}
+	return append(errs, e.errs...)
+}
+
+func containsType(types []reflect.Type, t reflect.Type) bool {
+	for _, candidate := range types {
+		if candidate == t {
+			return true
+		}
+	}
+	return false
+}
+
+// hasEnv reports whether any env var read by the fields of a struct type,
+// including those of nested structs, is set.
+func hasEnv(refType reflect.Type, prefix string, opts Options) bool {
+	return hasEnvVisit(refType, prefix, opts, map[reflect.Type]bool{})
+}
+
+func hasEnvVisit(refType reflect.Type, prefix string, opts Options, visited map[reflect.Type]bool) bool {
+	if visited[refType] {
+		// recursive types would otherwise never terminate
+		return false
+	}
+	visited[refType] = true
+	for i := 0; i < refType.NumField(); i++ {
+		refTypeField := patternField(refType.Field(i), opts)
+		fieldPrefix := prefix + refTypeField.Tag.Get("envPrefix")
+		if refTypeField.PkgPath != "" && !refTypeField.Anonymous {
+			continue
+		}
+		opts := opts
+		var skip bool
+		if opts.Group, skip = fieldGroup(refTypeField, opts.Group); skip {
+			continue
+		}
+		name, hasTag := refTypeField.Tag.Lookup("env")
+		if !hasTag {
+			fieldType := refTypeField.Type
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct && hasEnvVisit(fieldType, fieldPrefix, opts, visited) {
+				return true
+			}
+			continue
+		}
+		if _, _, _, ok := lookupField(refTypeField, fieldPrefix, opts); ok {
+			return true
+		}
+		if strings.ToLower(refTypeField.Tag.Get("envIndexed")) == "true" && maxIndex(fieldPrefix+name+"_", opts) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTypes walks a struct type the same way doParse walks its value and
+// reports the first tagged field whose type set could not handle.
+func checkTypes(refType reflect.Type, opts Options) error {
+	return checkTypesVisit(refType, opts, map[reflect.Type]bool{})
+}
+
+func checkTypesVisit(refType reflect.Type, opts Options, visited map[reflect.Type]bool) error {
+	if visited[refType] {
+		return nil
+	}
+	visited[refType] = true
+	for i := 0; i < refType.NumField(); i++ {
+		refTypeField := patternField(refType.Field(i), opts)
+		if refTypeField.PkgPath != "" && !refTypeField.Anonymous {
+			continue
+		}
+		fieldType := refTypeField.Type
+		if _, hasTag := refTypeField.Tag.Lookup("env"); !hasTag {
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				if err := checkTypesVisit(fieldType, opts, visited); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if strings.ToLower(refTypeField.Tag.Get("envIndexed")) == "true" && fieldType.Kind() == reflect.Slice && fieldType.Elem().Kind() == reflect.Struct {
+			if err := checkTypesVisit(fieldType.Elem(), opts, visited); err != nil {
+				return err
+			}
+			continue
+		}
+		if !isSupportedType(fieldType, refTypeField.Tag, opts) {
+			return fmt.Errorf("field %s: %w", refTypeField.Name, ErrUnsupportedType)
+		}
+	}
+	return nil
+}
+
+// isSupportedType reports whether set can assign a value to a field of the
+// given type.  It must be kept in step with set and handleSlice.
+func isSupportedType(t reflect.Type, tag reflect.StructTag, opts Options) bool {
+	if tag.Get("envParser") != "" || strings.ToLower(tag.Get("envJSON")) == "true" {
+		return true
+	}
+	if _, ok := opts.Parsers[t]; ok {
+		return true
+	}
+	if _, ok := opts.ContextParsers[t]; ok {
+		return true
+	}
+	if _, ok := opts.Enums[t]; ok {
+		return true
+	}
+	if reflect.PtrTo(t).Implements(flagValueType) || reflect.PtrTo(t).Implements(typedValueType) {
+		return true
+	}
+	if _, ok := opts.Funcs[t]; ok {
+		return true
+	}
+	if t.Kind() == reflect.Struct && strings.ToLower(tag.Get("envInline")) == "true" {
+		return true
+	}
+	if strings.ToLower(tag.Get("envRange")) == "true" {
+		_, ok := rangeType(t)
+		return ok
+	}
+	if tag.Get("envDecode") != "" {
+		return t.Kind() == reflect.String || ((t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() == reflect.Uint8)
+	}
+	if isScalarPointer(t) {
+		t = t.Elem()
+	}
+	if strings.ToLower(tag.Get("envRunes")) == "true" {
+		return t == reflect.TypeOf([]rune(nil))
+	}
+	if strings.ToLower(tag.Get("envLazy")) == "true" {
+		return t == reflect.TypeOf((func() string)(nil))
+	}
+	if strings.ToLower(tag.Get("envInfer")) == "true" {
+		return t.Kind() == reflect.Interface && t.NumMethod() == 0
+	}
+	if strings.ToLower(tag.Get("envPresence")) == "true" {
+		return t.Kind() == reflect.Bool
+	}
+	if tag.Get("envDuration") != "" {
+		return t == reflect.TypeOf(time.Duration(0))
+	}
+	if tag.Get("envFormat") != "" {
+		return isUUIDType(t)
+	}
+	if tag.Get("envURL") != "" {
+		return t == reflect.TypeOf(url.URL{}) || t == sliceOfURLs
+	}
+	if tag.Get("envTime") != "" {
+		return t == reflect.TypeOf(time.Time{})
+	}
+	if tag.Get("envLayouts") != "" {
+		return t == sliceOfTimes
+	}
+	if strings.ToLower(tag.Get("envRate")) == "true" {
+		return t == reflect.TypeOf(time.Duration(0)) || t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64
+	}
+	if tag.Get("envUnit") != "" {
+		switch t.Kind() {
+		case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64, reflect.Float32, reflect.Float64:
+			return true
+		}
+		return false
+	}
+	switch t {
+	case reflect.TypeOf(url.URL{}), reflect.TypeOf((*time.Location)(nil)), reflect.TypeOf(time.Weekday(0)), reflect.TypeOf(time.Month(0)),
+		reflect.TypeOf(time.Duration(0)), reflect.TypeOf(imagecolor.RGBA{}),
+		reflect.TypeOf(mail.Address{}), reflect.TypeOf((*mail.Address)(nil)), reflect.TypeOf(net.IPNet{}),
+		reflect.TypeOf(slog.Level(0)):
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Slice:
+		switch reflect.SliceOf(t.Elem()) {
+		case sliceOfStrings, sliceOfInts, sliceOfInt64s, sliceOfUint64s, sliceOfFloat32s,
+			sliceOfFloat64s, sliceOfBools, sliceOfDurations, sliceOfURLs, sliceOfAddresses, sliceOfIPNets:
+			return true
+		}
+		elemType := t.Elem()
+		if elemType.Kind() == reflect.Ptr {
+			elemType = elemType.Elem()
+		}
+		return reflect.PtrTo(elemType).Implements(textUnmarshalerType) ||
+			(isNumericKind(elemType.Kind()) && t.Elem().Kind() != reflect.Ptr) ||
+			isPairType(t.Elem())
+	case reflect.Map:
+		return isMapKeyKind(t.Key().Kind()) && (isSetType(t) || isSupportedType(t.Elem(), mapValueTag(tag), opts))
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Uint, reflect.Float32,
+		reflect.Float64, reflect.Int64, reflect.Uint64, reflect.Uintptr:
+		return true
+	case reflect.Ptr:
+		return t.Implements(textUnmarshalerType)
+	}
+	return reflect.PtrTo(t).Implements(scannerType) || reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+func get(field reflect.StructField, prefix string, opts Options) (string, error) {
+	fieldPrefix := prefix + field.Tag.Get("envPrefix")
+	key := fieldPrefix + field.Tag.Get("env")
+
+	var envRequired = false
+	reqTag, hasRequiredTag := field.Tag.Lookup("required")
+	if hasRequiredTag {
+		var b bool
+		var err error
+		if b, err = strconv.ParseBool(reqTag); err != nil {
+			// The value provided for the required tag is not a valid
+			// Boolean, so inform the user.
+			return "", fmt.Errorf("invalid required tag %q: %v", reqTag, err)
+		}
+		if b {
+			envRequired = true
+		}
+	}
+
+	if ref := field.Tag.Get("envSecretRef"); ref != "" {
+		if opts.SecretResolver == nil {
+			return "", fmt.Errorf("field %s has envSecretRef %q but no SecretResolver is configured", field.Name, ref)
+		}
+		value, err := opts.SecretResolver.Resolve(ref)
+		if err != nil {
+			return "", fmt.Errorf("unable to resolve secret %q for %s: %v", ref, field.Name, err)
+		}
+		if opts.provenance != nil {
+			opts.provenance[key] = Provenance{Origin: OriginSecret, Source: -1, Key: ref}
+		}
+		return value, nil
+	}
+
+	value, found, deprecated, envFound := lookupField(field, fieldPrefix, opts)
+	if deprecated && OnDeprecatedKey != nil && !opts.quiet {
+		OnDeprecatedKey(field, fieldPrefix+field.Tag.Get("envDeprecated"), key)
+	}
+	if message := field.Tag.Get("envDeprecatedField"); envFound && message != "" && OnDeprecatedField != nil && !opts.quiet {
+		OnDeprecatedField(field, key, message)
+	}
+
+	if cond := field.Tag.Get("envRequiredIf"); !envFound && !envRequired && cond != "" {
+		var ownerType reflect.Type
+		if opts.owner.IsValid() {
+			ownerType = opts.owner.Type()
+		}
+		var err error
+		if envRequired, err = requiredIf(cond, fieldPrefix, ownerType, opts); err != nil {
+			return "", err
+		}
+	}
+
+	if !envFound && envRequired {
+		return "", &missingError{key: key}
+	}
+
+	origin := Provenance{Origin: OriginDefault, Source: -1, Key: key}
+	if envFound {
+		origin = Provenance{Origin: OriginEnv, Source: found.source, Key: found.key}
+		if found.source >= 0 {
+			origin.Origin = OriginSource
+		}
+	}
+
+	if strings.ToLower(field.Tag.Get("envPresence")) == "true" {
+		// only whether the variable is set matters, not its value
+		if opts.provenance != nil && envFound {
+			opts.provenance[key] = origin
+		}
+		return strconv.FormatBool(envFound), nil
+	}
+
+	if envFound && strings.ToLower(field.Tag.Get("envStripComment")) == "true" {
+		value = stripComment(value)
+	}
+
+	if !envFound {
+		// apply default if one exists
+		value = defaultValue(field, key, opts)
+		if strings.ToLower(field.Tag.Get("envTemplate")) == "true" {
+			value = expandTemplate(value, fieldPrefix, opts)
+		}
+	}
+
+	expandVar := field.Tag.Get("envExpand")
+	if strings.ToLower(expandVar) == "true" {
+		value = os.ExpandEnv(value)
+	}
+
+	if len(opts.Resolvers) > 0 {
+		origin.Resolver = resolverScheme(value, opts.Resolvers)
+		var err error
+		if value, err = resolve(value, opts.Resolvers); err != nil {
+			return "", err
+		}
+	}
+
+	if strings.ToLower(field.Tag.Get("envUnquote")) == "true" {
+		value = unquote(value)
+	}
+
+	if strings.ToLower(field.Tag.Get("envMultiline")) == "true" {
+		// a literal \n stands for a newline, which many tools cannot pass
+		value = strings.ReplaceAll(value, `\n`, "\n")
+	}
+
+	if opts.provenance != nil && (envFound || value != "") {
+		opts.provenance[key] = origin
+	}
+	return value, nil
+}
+
+// expandTemplate replaces ${KEY} references in a default with the value
+// already parsed for KEY, which is prefixed like the field itself.  Keys not
+// parsed (yet) are looked up in the environment instead.
+func expandTemplate(value, prefix string, opts Options) string {
+	return os.Expand(value, func(name string) string {
+		if resolved, ok := opts.resolved[prefix+name]; ok {
+			return resolved
+		}
+		resolved, _ := lookupEnv(prefix+name, opts)
+		return resolved
+	})
+}
+
+// inferValue returns value as an int, a float64 or a bool, whichever parses
+// first, or else as the string itself.
+func inferValue(value string) interface{} {
+	if i, err := strconv.Atoi(value); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+	if b, err := strconv.ParseBool(value); err == nil {
+		return b
+	}
+	return value
+}
+
+// stripComment removes a trailing # comment, and the space before it, from a
+// value.  An escaped \# is kept as a literal #.
+func stripComment(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) && value[i+1] == '#' {
+			b.WriteByte('#')
+			i++
+			continue
+		}
+		if value[i] == '#' {
+			return strings.TrimRight(b.String(), " \t")
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+// unquote strips one layer of matching single or double quotes.
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// requiredIf evaluates an envRequiredIf condition of the form KEY=VALUE.  KEY
+// is looked up with the same prefix as the field, falling back to the default
+// of the sibling field tagged with KEY, and compared case-insensitively, or
+// as booleans when that sibling is a bool, so TLS=true also holds for TLS=1.
+// The condition reads the variable itself rather than the parsed field, so
+// it does not depend on the order in which fields are parsed.
+func requiredIf(cond, prefix string, ownerType reflect.Type, opts Options) (bool, error) {
+	kv := strings.SplitN(cond, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return false, fmt.Errorf("invalid envRequiredIf tag %q: expected KEY=VALUE", cond)
+	}
+	key := prefix + kv[0]
+	var sibling *reflect.StructField
+	if ownerType != nil {
+		for i := 0; i < ownerType.NumField(); i++ {
+			if field := ownerType.Field(i); field.Tag.Get("env") == kv[0] {
+				sibling = &field
+				break
+			}
+		}
+	}
+	value, ok := lookupEnv(key, opts)
+	if !ok && sibling != nil {
+		value = defaultValue(*sibling, key, opts)
+	}
+	if sibling != nil && (sibling.Type.Kind() == reflect.Bool || isScalarPointer(sibling.Type) && sibling.Type.Elem().Kind() == reflect.Bool) {
+		want, err := strconv.ParseBool(kv[1])
+		if err != nil {
+			return false, fmt.Errorf("invalid envRequiredIf tag %q: %s is a bool", cond, kv[0])
+		}
+		got, err := strconv.ParseBool(value)
+		return err == nil && got == want, nil
+	}
+	return strings.EqualFold(value, kv[1]), nil
+}
+
+// lookupField looks up the value of a field under its key, with
+// Options.PrefixFallback under shorter prefixes, and then under its
+// envDeprecated key.  It returns where the value was found and whether that
+// is under the deprecated key.  get, Missing and hasEnv all look fields up
+// through it.
+func lookupField(field reflect.StructField, prefix string, opts Options) (value string, found match, deprecated, ok bool) {
+	if value, found, ok = lookupPrefixed(prefix, field.Tag.Get("env"), opts); ok {
+		return value, found, false, true
+	}
+	if oldName := field.Tag.Get("envDeprecated"); oldName != "" {
+		if value, found, ok = lookupSet(prefix+oldName, opts); ok {
+			return value, found, true, true
+		}
+	}
+	return "", match{}, false, false
+}
+
+// lookupSet is lookupMatch, except that with Options.TreatBlankAsUnset a
+// value that is empty or only whitespace counts as unset.
+func lookupSet(key string, opts Options) (string, match, bool) {
+	value, found, ok := lookupMatch(key, opts)
+	if ok && opts.TreatBlankAsUnset && strings.TrimSpace(value) == "" {
+		return "", match{}, false
+	}
+	return value, found, ok
+}
+
+// lookupPrefixed looks up prefix+name and, with Options.PrefixFallback, the
+// name under ever shorter prefixes.
+func lookupPrefixed(prefix, name string, opts Options) (string, match, bool) {
+	value, found, ok := lookupSet(prefix+name, opts)
+	if ok || !opts.PrefixFallback {
+		return value, found, ok
+	}
+	delimiter := opts.PrefixDelimiter
+	if delimiter == "" {
+		delimiter = "_"
+	}
+	for prefix != "" {
+		if i := strings.Index(prefix, delimiter); i >= 0 {
+			prefix = prefix[i+len(delimiter):]
+		} else {
+			prefix = ""
+		}
+		if value, found, ok = lookupSet(prefix+name, opts); ok {
+			return value, found, true
+		}
+	}
+	return "", match{}, false
+}
+
+// match describes where lookupMatch found a key: the raw key that matched,
+// which differs from the key looked up after Options.KeyRewrite or
+// NormalizeKey, and the index in Options.Sources of the source that had it,
+// or -1 for the environment and the overrides.
+type match struct {
+	key    string
+	source int
+}
+
+// lookupEnv consults Options.Sources in order and then the environment, or
+// only the overrides during ApplyOverrides.
+func lookupEnv(key string, opts Options) (string, bool) {
+	value, _, ok := lookupMatch(key, opts)
+	return value, ok
+}
+
+// lookupMatch is lookupEnv that also reports where the key matched.  Outside
+// of overrides the key is rewritten by Options.KeyRewrite first.  With
+// Options.NormalizeKey set, the key matches any env var whose name normalizes
+// to the same string.
+func lookupMatch(key string, opts Options) (string, match, bool) {
+	if opts.overrides != nil {
+		value, ok := opts.overrides[key]
+		return value, match{key: key, source: -1}, ok
+	}
+	key = rewriteKey(key, opts)
+	for i, source := range opts.Sources {
+		if value, ok := source.Lookup(key); ok {
+			return value, match{key: key, source: i}, true
+		}
+	}
+	if value, ok := os.LookupEnv(key); ok || opts.NormalizeKey == nil {
+		return value, match{key: key, source: -1}, ok
+	}
+	normalized := opts.NormalizeKey(key)
+	for _, name := range environKeys() {
+		if opts.NormalizeKey(name) == normalized {
+			value, ok := os.LookupEnv(name)
+			return value, match{key: name, source: -1}, ok
+		}
+	}
+	return "", match{}, false
+}
+
+// rewriteKey applies Options.KeyRewrite to a computed key.
+func rewriteKey(key string, opts Options) string {
+	if opts.KeyRewrite == nil {
+		return key
+	}
+	return opts.KeyRewrite(key)
+}
+
+// defaultValue returns the default for a field that has no env var set.  The
+// envDefault tag takes precedence over Options.Defaults unless
+// Options.DefaultsOverrideTags is set.
+func defaultValue(field reflect.StructField, key string, opts Options) string {
+	tagDefault, hasTagDefault := field.Tag.Lookup("envDefault")
+	mapDefault, hasMapDefault := opts.Defaults[key]
+	if hasMapDefault && (!hasTagDefault || opts.DefaultsOverrideTags) {
+		return mapDefault
+	}
+	return tagDefault
+}
+
+// resolve passes a value of the form scheme://... to the resolver registered
+// for that scheme.  Values without a registered scheme are returned unchanged.
+func resolve(value string, resolvers map[string]func(uri string) (string, error)) (string, error) {
+	scheme := resolverScheme(value, resolvers)
+	if scheme == "" {
+		return value, nil
+	}
+	resolved, err := resolvers[scheme](value)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve %q: %v", value, err)
+	}
+	return resolved, nil
+}
+
+// resolverScheme returns the scheme of a value of the form scheme://... when
+// a resolver is registered for it, and "" otherwise.
+func resolverScheme(value string, resolvers map[string]func(uri string) (string, error)) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" {
+		return ""
+	}
+	if _, ok := resolvers[u.Scheme]; !ok {
+		return ""
+	}
+	return u.Scheme
+}
+
+func set(field reflect.Value, refType reflect.StructField, value string, opts Options) error {
+	if isScalarPointer(refType.Type) {
+		return setPointer(field, refType, value, opts)
+	}
+
+	if strings.ToLower(refType.Tag.Get("envGrouping")) == "true" && isNumericKind(field.Kind()) {
+		stripped, err := stripGrouping(value)
+		if err != nil {
+			return newParseError(refType, value, err)
+		}
+		value = stripped
+	}
+
+	if strings.ToLower(refType.Tag.Get("envExpr")) == "true" {
+		n, err := evalExpr(value)
+		if err != nil {
+			return err
+		}
+		value = strconv.FormatInt(n, 10)
+		if refType.Type == reflect.TypeOf(time.Duration(0)) {
+			// the result of an expression is a number of seconds
+			value += "s"
+		}
+	}
+
+	if name := refType.Tag.Get("envParser"); name != "" {
+		return handleMethodParser(field, refType, value, name, opts.owner)
+	}
+
+	if strings.ToLower(refType.Tag.Get("envJSON")) == "true" {
+		return handleJSON(field, value, refType)
+	}
+
+	// []rune is the same type as []int32, which is parsed as a list of
+	// numbers, so taking the runes of the value has to be asked for
+	if strings.ToLower(refType.Tag.Get("envRunes")) == "true" {
+		if refType.Type != reflect.TypeOf([]rune(nil)) {
+			return ErrUnsupportedType
+		}
+		field.Set(reflect.ValueOf([]rune(value)))
+		return nil
+	}
+
+	if strings.ToLower(refType.Tag.Get("envInfer")) == "true" {
+		if refType.Type.Kind() != reflect.Interface || refType.Type.NumMethod() != 0 {
+			return ErrUnsupportedType
+		}
+		field.Set(reflect.ValueOf(inferValue(value)))
+		return nil
+	}
+
+	// use custom parser if configured for this type
+	parserFunc, ok := opts.Parsers[refType.Type]
+	if ctxParser, found := opts.ContextParsers[refType.Type]; found && !ok {
+		parserFunc, ok = func(v string) (interface{}, error) {
+			return callContextParser(ctxParser, v, refType, opts)
+		}, true
+	}
+	if ok {
+		val, err := parserFunc(value)
+		if err != nil {
+			return fmt.Errorf("Custom parser error: %v", err)
+		}
+		// the parser of an interface type such as net.Addr returns a
+		// concrete value, which must implement the interface
+		v := reflect.ValueOf(val)
+		if !v.IsValid() {
+			v = reflect.Zero(field.Type())
+		}
+		if !v.Type().AssignableTo(field.Type()) {
+			return fmt.Errorf("Custom parser error: %T is not assignable to %s", val, field.Type())
+		}
+		field.Set(v)
+		return nil
+	}
+
+	// types that implement flag.Value, or the Set and Type methods of a
+	// pflag value, parse themselves
+	if field.CanAddr() {
+		if fv, ok := field.Addr().Interface().(flag.Value); ok {
+			if err := fv.Set(value); err != nil {
+				return newParseError(refType, value, err)
+			}
+			return nil
+		}
+		if fv, ok := field.Addr().Interface().(typedValue); ok {
+			if err := fv.Set(value); err != nil {
+				return newParseError(refType, value, err)
+			}
+			return nil
+		}
+	}
+
+	// use the enum registry if this type has registered names
+	if names, ok := opts.Enums[refType.Type]; ok {
+		val, ok := names[value]
+		if !ok {
+			return &validationError{fmt.Errorf("invalid value %q for %s: expected one of %s", value, refType.Type, strings.Join(enumNames(names), ", "))}
+		}
+		field.Set(reflect.ValueOf(val).Convert(refType.Type))
+		return nil
+	}
+
+	// string types that list their own valid values
+	if values, ok := enumValues(field); ok {
+		if !containsString(values, value) {
+			return newParseError(refType, value, &validationError{fmt.Errorf("expected one of %s", strings.Join(values, ", "))})
+		}
+		field.SetString(value)
+		return nil
+	}
+
+	// select a registered function by name
+	if reflect.Func == field.Kind() {
+		funcs, ok := opts.Funcs[refType.Type]
+		if !ok {
+			return ErrUnsupportedType
+		}
+		fn, ok := funcs[value]
+		if !ok {
+			return fmt.Errorf("unknown function %q for %s: expected one of %s", value, refType.Name, strings.Join(enumNames(funcs), ", "))
+		}
+		field.Set(reflect.ValueOf(fn))
+		return nil
+	}
+
+	if reflect.Struct == field.Kind() && strings.ToLower(refType.Tag.Get("envInline")) == "true" {
+		return handleInline(field, value, refType, opts)
+	}
+
+	if strings.ToLower(refType.Tag.Get("envRange")) == "true" {
+		return handleRange(field, value, refType, opts)
+	}
+
+	if unit := refType.Tag.Get("envUnit"); unit != "" {
+		return handleUnit(field, value, unit)
+	}
+
+	if strings.ToLower(refType.Tag.Get("envRate")) == "true" {
+		return handleRate(field, value)
+	}
+
+	if mode := refType.Tag.Get("envDuration"); mode != "" {
+		return handleDuration(field, value, mode, refType)
+	}
+
+	if mode := refType.Tag.Get("envTime"); mode != "" {
+		return handleRelativeTime(field, value, mode, refType)
+	}
+
+	if mode := refType.Tag.Get("envDecode"); mode != "" {
+		return handleDecode(field, value, mode, refType)
+	}
+
+	if format := refType.Tag.Get("envFormat"); format != "" {
+		if strings.ToLower(format) != "uuid" {
+			return fmt.Errorf("invalid envFormat tag %q", format)
+		}
+		if !isUUIDType(field.Type()) {
+			return ErrUnsupportedType
+		}
+		id, err := parseUUID(value)
+		if err != nil {
+			return newParseError(refType, value, err)
+		}
+		reflect.Copy(field, reflect.ValueOf(id[:]))
+		return nil
+	}
+
+	// big.Rat is also a TextUnmarshaler, but parsing it here reports which
+	// field the malformed value belongs to
+	if field.Type() == reflect.TypeOf(big.Rat{}) || field.Type() == reflect.TypeOf((*big.Rat)(nil)) {
+		r, ok := new(big.Rat).SetString(value)
+		if !ok {
+			return newParseError(refType, value, errors.New("invalid rational number, expected a fraction such as 3/7 or a decimal such as 0.15"))
+		}
+		if field.Kind() == reflect.Ptr {
+			field.Set(reflect.ValueOf(r))
+		} else {
+			field.Set(reflect.ValueOf(*r))
+		}
+		return nil
+	}
+
+	if refType.Type == reflect.TypeOf(url.URL{}) {
+		u, err := url.Parse(value)
+		if err != nil {
+			return fmt.Errorf("Unable to complete URL parse: %v", err)
+		}
+		if err := checkURL(u, refType.Tag); err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(*u))
+		return nil
+	}
+
+	switch refType.Type {
+	case reflect.TypeOf((*time.Location)(nil)):
+		loc, err := time.LoadLocation(value)
+		if err != nil {
+			return newParseError(refType, value, fmt.Errorf("unknown time zone: %v", err))
+		}
+		field.Set(reflect.ValueOf(loc))
+		return nil
+	case reflect.TypeOf(time.Weekday(0)):
+		weekday, err := parseWeekday(value)
+		if err != nil {
+			return newParseError(refType, value, err)
+		}
+		field.Set(reflect.ValueOf(weekday))
+		return nil
+	case reflect.TypeOf(time.Month(0)):
+		month, err := parseMonth(value)
+		if err != nil {
+			return newParseError(refType, value, err)
+		}
+		field.Set(reflect.ValueOf(month))
+		return nil
+	case reflect.TypeOf(slog.Level(0)):
+		level, err := parseLevel(value)
+		if err != nil {
+			return newParseError(refType, value, err)
+		}
+		field.Set(reflect.ValueOf(level))
+		return nil
+	case reflect.TypeOf(mail.Address{}), reflect.TypeOf((*mail.Address)(nil)):
+		addr, err := mail.ParseAddress(value)
+		if err != nil {
+			return newParseError(refType, value, err)
+		}
+		if field.Kind() == reflect.Ptr {
+			field.Set(reflect.ValueOf(addr))
+		} else {
+			field.Set(reflect.ValueOf(*addr))
+		}
+		return nil
+	case reflect.TypeOf(net.IPNet{}):
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return newParseError(refType, value, err)
+		}
+		field.Set(reflect.ValueOf(*ipNet))
+		return nil
+	case reflect.TypeOf(imagecolor.RGBA{}):
+		c, err := parseHexColor(value)
+		if err != nil {
+			return newParseError(refType, value, err)
+		}
+		field.Set(reflect.ValueOf(c))
+		return nil
+	case reflect.TypeOf(json.Number("")):
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return newParseError(refType, value, err)
+		}
+		field.SetString(value)
+		return nil
+	}
+
+	// fall back to built-in parsers
+	switch field.Kind() {
+	case reflect.Slice:
+		if err := handleSlice(field, value, refType); err != nil {
+			return err
+		}
+		if order := refType.Tag.Get("envSort"); order != "" {
+			return sortSlice(field, order)
+		}
+	case reflect.Map:
+		return handleMap(field, value, refType, opts)
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		bvalue, err := parseBool(value, refType.Tag)
+		if err != nil {
+			return newParseError(refType, value, err)
+		}
+		field.SetBool(bvalue)
+	case reflect.Int:
+		intValue, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return newParseError(refType, value, err)
+		}
+		field.SetInt(intValue)
+	case reflect.Uint:
+		uintValue, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return newParseError(refType, value, err)
+		}
+		field.SetUint(uintValue)
+	case reflect.Float32:
+		v, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return newParseError(refType, value, err)
+		}
+		field.SetFloat(v)
+	case reflect.Float64:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return newParseError(refType, value, err)
+		}
+		field.Set(reflect.ValueOf(v))
+	case reflect.Int64:
+		if refType.Type.String() == "time.Duration" {
+			dValue, err := time.ParseDuration(value)
+			if err != nil {
+				return newParseError(refType, value, err)
+			}
+			field.Set(reflect.ValueOf(dValue))
+		} else {
+			intValue, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return newParseError(refType, value, err)
+			}
+			field.SetInt(intValue)
+		}
+	case reflect.Uint64:
+		uintValue, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return newParseError(refType, value, err)
+		}
+		field.SetUint(uintValue)
+	case reflect.Uintptr:
+		// base 0 so that handles can be given in hex, such as 0x1f
+		uintValue, err := strconv.ParseUint(value, 0, 64)
+		if err != nil {
+			return newParseError(refType, value, err)
+		}
+		field.SetUint(uintValue)
+	default:
+		if scanner, ok := asScanner(field); ok {
+			return scanner.Scan(value)
+		}
+		return handleTextUnmarshaler(field, value)
+	}
+	return nil
+}
+
+// asScanner returns the sql.Scanner implemented by a pointer to the field.
+// This covers the database/sql Null types such as sql.NullString, whose Scan
+// method sets Valid when given a value.
+func asScanner(field reflect.Value) (sql.Scanner, bool) {
+	if !field.CanAddr() {
+		return nil, false
+	}
+	scanner, ok := field.Addr().Interface().(sql.Scanner)
+	return scanner, ok
+}
+
+// handleJSON decodes a JSON value into a field of any type encoding/json
+// supports, such as map[string]ServerConfig.  The field is only replaced
+// once the whole value has decoded.
+func handleJSON(field reflect.Value, value string, refType reflect.StructField) error {
+	ptr := reflect.New(field.Type())
+	if err := json.Unmarshal([]byte(value), ptr.Interface()); err != nil {
+		return newParseError(refType, value, err)
+	}
+	field.Set(ptr.Elem())
+	return nil
+}
+
+// handleMethodParser calls the method named by the envParser tag on the
+// struct that declares the field.  A func(string) error method sets the field
+// itself; a func(string) (interface{}, error) method returns the value.  The
+// method runs on a copy of the struct so that only the field is updated.
+func handleMethodParser(field reflect.Value, refType reflect.StructField, value, name string, owner reflect.Value) error {
+	if !owner.IsValid() {
+		return fmt.Errorf("envParser method %s is only supported on struct fields", name)
+	}
+	scratch := reflect.New(owner.Type())
+	scratch.Elem().Set(owner)
+	method := scratch.MethodByName(name)
+	if !method.IsValid() {
+		return fmt.Errorf("envParser method %s not found on %s", name, scratch.Type())
+	}
+
+	switch fn := method.Interface().(type) {
+	case func(string) error:
+		if err := fn(value); err != nil {
+			return newParseError(refType, value, err)
+		}
+		field.Set(scratch.Elem().FieldByIndex(refType.Index))
+	case func(string) (interface{}, error):
+		val, err := fn(value)
+		if err != nil {
+			return newParseError(refType, value, err)
+		}
+		v := reflect.ValueOf(val)
+		if !v.IsValid() || !v.Type().AssignableTo(field.Type()) {
+			return fmt.Errorf("envParser method %s returned %T, expected %s", name, val, field.Type())
+		}
+		field.Set(v)
+	default:
+		return fmt.Errorf("envParser method %s must be func(string) error or func(string) (interface{}, error)", name)
+	}
+	return nil
+}
+
+// valuesLister is implemented by generated string enums that list their
+// valid values.
+type valuesLister interface {
+	Values() []string
+}
+
+// callContextParser calls a context-aware parser with Options.Context, bounded
+// by the envTimeout tag of the field when it has one.
+func callContextParser(parser ContextParserFunc, value string, refType reflect.StructField, opts Options) (interface{}, error) {
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	timeoutTag := refType.Tag.Get("envTimeout")
+	if timeoutTag == "" {
+		return parser(ctx, value)
+	}
+	timeout, err := time.ParseDuration(timeoutTag)
+	if err != nil || timeout <= 0 {
+		return nil, fmt.Errorf("invalid envTimeout tag %q", timeoutTag)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	val, err := parser(ctx, value)
+	if errors.Is(err, context.DeadlineExceeded) || (err == nil && errors.Is(ctx.Err(), context.DeadlineExceeded)) {
+		return nil, fmt.Errorf("parser for field %s timed out after %s", refType.Name, timeout)
+	}
+	return val, err
+}
+
+// enumValues returns the valid values of a string-kind field whose type, or
+// a pointer to it, implements valuesLister.
+func enumValues(field reflect.Value) ([]string, bool) {
+	if field.Kind() != reflect.String {
+		return nil, false
+	}
+	if lister, ok := field.Interface().(valuesLister); ok {
+		return lister.Values(), true
+	}
+	if field.CanAddr() {
+		if lister, ok := field.Addr().Interface().(valuesLister); ok {
+			return lister.Values(), true
+		}
+	}
+	return nil, false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// enumNames returns the registered names of an enum in sorted order.
+func enumNames(names map[string]interface{}) []string {
+	list := make([]string, 0, len(names))
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// rangeType returns the type of the Min and Max fields of a struct used with
+// envRange, which must be the same int, uint, float or duration type.
+func rangeType(t reflect.Type) (reflect.Type, bool) {
+	if t.Kind() != reflect.Struct {
+		return nil, false
+	}
+	minField, hasMin := t.FieldByName("Min")
+	maxField, hasMax := t.FieldByName("Max")
+	if !hasMin || !hasMax || minField.Type != maxField.Type {
+		return nil, false
+	}
+	switch minField.Type.Kind() {
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return minField.Type, true
+	}
+	return nil, false
+}
+
+// handleRange populates the Min and Max fields of a struct from a single
+// value such as "1s..30s", and checks that Min is not greater than Max.
+func handleRange(field reflect.Value, value string, refType reflect.StructField, opts Options) error {
+	boundType, ok := rangeType(field.Type())
+	if !ok {
+		return fmt.Errorf("field %s: envRange requires a struct with Min and Max fields of the same number type: %w", refType.Name, ErrUnsupportedType)
+	}
+	bounds := strings.SplitN(value, "..", 2)
+	if len(bounds) != 2 {
+		return fmt.Errorf("invalid range %q for %s: expected MIN..MAX", value, refType.Name)
+	}
+	lo := reflect.New(boundType).Elem()
+	hi := reflect.New(boundType).Elem()
+	if err := set(lo, reflect.StructField{Name: refType.Name + ".Min", Type: boundType}, strings.TrimSpace(bounds[0]), opts); err != nil {
+		return err
+	}
+	if err := set(hi, reflect.StructField{Name: refType.Name + ".Max", Type: boundType}, strings.TrimSpace(bounds[1]), opts); err != nil {
+		return err
+	}
+	var reversed bool
+	switch boundType.Kind() {
+	case reflect.Int, reflect.Int64:
+		reversed = lo.Int() > hi.Int()
+	case reflect.Uint, reflect.Uint64:
+		reversed = lo.Uint() > hi.Uint()
+	default:
+		reversed = lo.Float() > hi.Float()
+	}
+	if reversed {
+		return &validationError{fmt.Errorf("invalid range %q for %s: Min is greater than Max", value, refType.Name)}
+	}
+	field.FieldByName("Min").Set(lo)
+	field.FieldByName("Max").Set(hi)
+	return nil
+}
+
+// handleInline populates a struct from a single value holding key=value pairs,
+// such as "cert=/a,key=/b".  Each key is matched against the env tags of the
+// struct's fields.
+func handleInline(field reflect.Value, value string, refType reflect.StructField, opts Options) error {
+	separator := tagSeparator(refType.Tag)
+	opts.owner = field
+
+	fieldType := field.Type()
+	for _, pair := range strings.Split(value, separator) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid inline pair %q: expected key=value", pair)
+		}
+		found := false
+		for i := 0; i < fieldType.NumField(); i++ {
+			innerType := fieldType.Field(i)
+			if innerType.Tag.Get("env") != kv[0] || !field.Field(i).CanSet() {
+				continue
+			}
+			if err := set(field.Field(i), innerType, kv[1], opts); err != nil {
+				return err
+			}
+			found = true
+			break
+		}
+		if !found {
+			return fmt.Errorf("unknown inline key %q for %s", kv[0], refType.Name)
+		}
+	}
+	return nil
+}
+
+// byteSizes maps the suffixes accepted by envUnit:"bytes" to their multiplier.
+var byteSizes = map[string]float64{
+	"":    1,
+	"B":   1,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+	"TIB": 1 << 40,
+}
+
+// handleUnit parses human-readable values for fields tagged with envUnit.
+// "bytes" accepts sizes such as 512MB or 2GiB and stores the byte count;
+// "percent" accepts values such as 75% and stores 0.75 in a float field or 75
+// in an integer field.
+func handleUnit(field reflect.Value, value, unit string) error {
+	var number float64
+	switch strings.ToLower(unit) {
+	case "bytes":
+		trimmed := strings.TrimSpace(value)
+		i := strings.IndexFunc(trimmed, func(r rune) bool {
+			return !(r >= '0' && r <= '9' || r == '.')
+		})
+		if i < 0 {
+			i = len(trimmed)
+		}
+		suffix := strings.TrimSpace(trimmed[i:])
+		multiplier, ok := byteSizes[strings.ToUpper(suffix)]
+		if !ok {
+			return fmt.Errorf("unknown byte size suffix %q in %q", suffix, value)
+		}
+		n, err := strconv.ParseFloat(trimmed[:i], 64)
+		if err != nil {
+			return fmt.Errorf("invalid byte size %q", value)
+		}
+		number = n * multiplier
+	case "percent":
+		trimmed := strings.TrimSpace(value)
+		if !strings.HasSuffix(trimmed, "%") {
+			return fmt.Errorf("invalid percentage %q: expected a trailing %%", value)
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(trimmed, "%")), 64)
+		if err != nil {
+			return fmt.Errorf("invalid percentage %q", value)
+		}
+		if field.Kind() == reflect.Float32 || field.Kind() == reflect.Float64 {
+			field.SetFloat(n / 100)
+			return nil
+		}
+		number = n
+	default:
+		return fmt.Errorf("invalid envUnit tag %q", unit)
+	}
+
+	switch field.Kind() {
+	case reflect.Int, reflect.Int64:
+		if number != float64(int64(number)) || field.OverflowInt(int64(number)) {
+			return fmt.Errorf("value %q does not fit in %s", value, field.Type())
+		}
+		field.SetInt(int64(number))
+	case reflect.Uint, reflect.Uint64:
+		if number < 0 || number != float64(uint64(number)) || field.OverflowUint(uint64(number)) {
+			return fmt.Errorf("value %q does not fit in %s", value, field.Type())
+		}
+		field.SetUint(uint64(number))
+	case reflect.Float32, reflect.Float64:
+		field.SetFloat(number)
+	default:
+		return ErrUnsupportedType
+	}
+	return nil
+}
+
+// decodeBytes decodes a value according to an envDecode tag mode.
+func decodeBytes(value, mode string) ([]byte, error) {
+	switch strings.ToLower(mode) {
+	case "hex":
+		return hex.DecodeString(value)
+	case "base64":
+		return base64.StdEncoding.DecodeString(value)
+	}
+	return nil, fmt.Errorf("invalid envDecode tag %q", mode)
+}
+
+// handleDecode decodes a hex or base64 value into a []byte, [N]byte or string
+// field.  An array must receive exactly N bytes.
+func handleDecode(field reflect.Value, value, mode string, refType reflect.StructField) error {
+	data, err := decodeBytes(value, mode)
+	if err != nil {
+		return newParseError(refType, value, fmt.Errorf("unable to decode %s value: %v", mode, err))
+	}
+	switch {
+	case field.Kind() == reflect.String:
+		field.SetString(string(data))
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Uint8:
+		field.SetBytes(data)
+	case field.Kind() == reflect.Array && field.Type().Elem().Kind() == reflect.Uint8:
+		if len(data) != field.Len() {
+			return newParseError(refType, value, fmt.Errorf("decoded %s value is %d bytes, expected %d", mode, len(data), field.Len()))
+		}
+		reflect.Copy(field, reflect.ValueOf(data))
+	default:
+		return ErrUnsupportedType
+	}
+	return nil
+}
+
+// rateUnits are the units accepted after the slash by envRate:"true".
+var rateUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// handleRate parses a rate such as 100/s.  A float field receives the number
+// of events per second, a time.Duration field the interval between events.
+func handleRate(field reflect.Value, value string) error {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid rate %q: expected <number>/<unit>", value)
+	}
+	unit, ok := rateUnits[strings.TrimSpace(parts[1])]
+	if !ok {
+		return fmt.Errorf("invalid rate %q: unknown unit %q", value, parts[1])
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("invalid rate %q: expected a positive number", value)
+	}
+
+	switch {
+	case field.Type() == reflect.TypeOf(time.Duration(0)):
+		field.SetInt(int64(float64(unit) / n))
+	case field.Kind() == reflect.Float32 || field.Kind() == reflect.Float64:
+		field.SetFloat(n / unit.Seconds())
+	default:
+		return ErrUnsupportedType
+	}
+	return nil
+}
+
+// isScalarPointer reports whether t is a pointer to a bool or number type,
+// such as *int or *time.Duration, which is left nil while its variable is
+// unset so that unset can be told apart from the zero value.
+func isScalarPointer(t reflect.Type) bool {
+	if t.Kind() != reflect.Ptr {
+		return false
+	}
+	switch t.Elem().Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// setPointer allocates the value behind a pointer field and parses into it
+// exactly as for a field of the pointed-to type.  The field stays nil while
+// its variable is unset, since set is only called with a value.
+func setPointer(field reflect.Value, refType reflect.StructField, value string, opts Options) error {
+	elemField := refType
+	elemField.Type = refType.Type.Elem()
+	ptr := reflect.New(elemField.Type)
+	if err := set(ptr.Elem(), elemField, value, opts); err != nil {
+		return err
+	}
+	field.Set(ptr)
+	return nil
+}
+
+// handleDuration parses a time.Duration field tagged with envDuration, a
+// comma-separated list of modes.  The "clock" mode accepts HH:MM:SS or MM:SS,
+// where the leading part may exceed its usual range, so 90:00 is ninety
+// minutes.  The "extended" mode also accepts days (d) and weeks (w), as in 2d
+// or 1w3d12h.  The "nonnegative" mode rejects negative durations and the
+// "sum" mode adds up parts joined by +, as in 1m+30s.  Both combine with
+// either of the others.
+func handleDuration(field reflect.Value, value, modes string, refType reflect.StructField) error {
+	if field.Type() != reflect.TypeOf(time.Duration(0)) {
+		return ErrUnsupportedType
+	}
+	parse := func(value string) (time.Duration, error) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q: %v", value, err)
+		}
+		return d, nil
+	}
+	format := ""
+	nonNegative, sum := false, false
+	for _, mode := range strings.Split(strings.ToLower(modes), ",") {
+		switch mode = strings.TrimSpace(mode); mode {
+		case "clock", "extended":
+			if format != "" {
+				return fmt.Errorf("invalid envDuration tag %q", modes)
+			}
+			format = mode
+			if mode == "clock" {
+				parse = parseClock
+			} else {
+				parse = parseExtendedDuration
+			}
+		case "nonnegative":
+			nonNegative = true
+		case "sum":
+			sum = true
+		default:
+			return fmt.Errorf("invalid envDuration tag %q", modes)
+		}
+	}
+	var d time.Duration
+	if sum {
+		for _, part := range strings.Split(value, "+") {
+			partDuration, err := parse(strings.TrimSpace(part))
+			if err != nil {
+				return newParseError(refType, value, fmt.Errorf("invalid part %q of duration sum: %v", part, err))
+			}
+			d += partDuration
+		}
+	} else {
+		var err error
+		if d, err = parse(value); err != nil {
+			return newParseError(refType, value, err)
+		}
+	}
+	if nonNegative && d < 0 {
+		return &validationError{fmt.Errorf("negative duration %q for %s: expected zero or more", value, refType.Name)}
+	}
+	field.SetInt(int64(d))
+	return nil
+}
+
+// handleRelativeTime parses a time.Time field tagged with envTime:"relative"
+// from now, now+DURATION or now-DURATION, relative to the time of parsing.
+func handleRelativeTime(field reflect.Value, value, mode string, refType reflect.StructField) error {
+	if field.Type() != reflect.TypeOf(time.Time{}) {
+		return ErrUnsupportedType
+	}
+	if strings.ToLower(mode) != "relative" {
+		return fmt.Errorf("invalid envTime tag %q", mode)
+	}
+	expected := errors.New("invalid relative time: expected now, now+DURATION or now-DURATION")
+	trimmed := strings.TrimSpace(value)
+	if !strings.HasPrefix(strings.ToLower(trimmed), "now") {
+		return newParseError(refType, value, expected)
+	}
+	var offset time.Duration
+	if rest := trimmed[len("now"):]; rest != "" {
+		if rest[0] != '+' && rest[0] != '-' {
+			return newParseError(refType, value, expected)
+		}
+		var err error
+		if offset, err = time.ParseDuration(rest[1:]); err != nil {
+			return newParseError(refType, value, fmt.Errorf("invalid relative time: %v", err))
+		}
+		if rest[0] == '-' {
+			offset = -offset
+		}
+	}
+	field.Set(reflect.ValueOf(time.Now().Add(offset)))
+	return nil
+}
+
+// extendedUnits matches the day and week components of an extended duration.
+var extendedUnits = regexp.MustCompile(`([0-9]*\.?[0-9]+)([dw])`)
+
+// parseExtendedDuration is time.ParseDuration with days and weeks, which are
+// rewritten as hours before parsing.
+func parseExtendedDuration(value string) (time.Duration, error) {
+	var convErr error
+	rewritten := extendedUnits.ReplaceAllStringFunc(value, func(part string) string {
+		m := extendedUnits.FindStringSubmatch(part)
+		n, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			convErr = err
+			return part
+		}
+		hours := 24.0
+		if m[2] == "w" {
+			hours = 7 * 24
+		}
+		return strconv.FormatFloat(n*hours, 'f', -1, 64) + "h"
+	})
+	if convErr != nil {
+		return 0, fmt.Errorf("invalid duration %q: %v", value, convErr)
+	}
+	d, err := time.ParseDuration(rewritten)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
+
+// parseClock converts HH:MM:SS or MM:SS into a time.Duration.
+func parseClock(value string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid clock duration %q: expected HH:MM:SS or MM:SS", value)
+	}
+	units := []time.Duration{time.Minute, time.Second}
+	if len(parts) == 3 {
+		units = []time.Duration{time.Hour, time.Minute, time.Second}
+	}
+	var d time.Duration
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || (i > 0 && n >= 60) {
+			return 0, fmt.Errorf("invalid clock duration %q: bad part %q", value, part)
+		}
+		// only the leading part is unbounded, and it must not overflow
+		if n > uint64(math.MaxInt64/int64(units[i])) || d+time.Duration(n)*units[i] < d {
+			return 0, fmt.Errorf("invalid clock duration %q: out of range", value)
+		}
+		d += time.Duration(n) * units[i]
+	}
+	return d, nil
+}
+
+// parseLevel parses a slog.Level from a name such as debug, info, warn or
+// error (case-insensitive), optionally with an offset as in INFO+2, or from
+// its number.
+func parseLevel(value string) (slog.Level, error) {
+	if n, err := strconv.Atoi(value); err == nil {
+		return slog.Level(n), nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return 0, errors.New("unknown log level, expected debug, info, warn or error")
+	}
+	return level, nil
+}
+
+// ValidateUUID checks that s is a UUID in the canonical 8-4-4-4-12 hex form,
+// such as 123e4567-e89b-12d3-a456-426614174000.  It suits a CustomParsers
+// entry for a UUID type from another package that should be validated first.
+func ValidateUUID(s string) error {
+	_, err := parseUUID(s)
+	return err
+}
+
+// parseUUID parses a UUID in the canonical 8-4-4-4-12 hex form, in either
+// case, into its 16 bytes.
+func parseUUID(s string) ([16]byte, error) {
+	var id [16]byte
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return id, fmt.Errorf("invalid UUID %q: expected the 8-4-4-4-12 hex form", s)
+	}
+	digits := s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:]
+	if _, err := hex.Decode(id[:], []byte(digits)); err != nil {
+		return id, fmt.Errorf("invalid UUID %q: %v", s, err)
+	}
+	return id, nil
+}
+
+// isUUIDType reports whether t can hold a UUID for envFormat:"uuid": a
+// [16]byte array, or a named type over one.
+func isUUIDType(t reflect.Type) bool {
+	return t.Kind() == reflect.Array && t.Len() == 16 && t.Elem().Kind() == reflect.Uint8
+}
+
+// parseHexColor parses a color in the form #RRGGBB or #RRGGBBAA.  Without an
+// alpha component the color is opaque.
+func parseHexColor(value string) (imagecolor.RGBA, error) {
+	digits := strings.TrimPrefix(value, "#")
+	if len(digits) == len(value) || (len(digits) != 6 && len(digits) != 8) {
+		return imagecolor.RGBA{}, errors.New("expected #RRGGBB or #RRGGBBAA")
+	}
+	b, err := hex.DecodeString(digits)
+	if err != nil {
+		return imagecolor.RGBA{}, fmt.Errorf("invalid hex color: %v", err)
+	}
+	c := imagecolor.RGBA{R: b[0], G: b[1], B: b[2], A: 0xff}
+	if len(b) == 4 {
+		c.A = b[3]
+	}
+	return c, nil
+}
+
+// parseWeekday accepts an English weekday name (case-insensitive) or its
+// number, with Sunday as 0.
+func parseWeekday(value string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), value) {
+			return d, nil
+		}
+	}
+	if n, err := strconv.Atoi(value); err == nil && n >= int(time.Sunday) && n <= int(time.Saturday) {
+		return time.Weekday(n), nil
+	}
+	return 0, fmt.Errorf("unknown weekday %q", value)
+}
+
+// parseMonth accepts an English month name (case-insensitive) or its number,
+// with January as 1.
+func parseMonth(value string) (time.Month, error) {
+	for m := time.January; m <= time.December; m++ {
+		if strings.EqualFold(m.String(), value) {
+			return m, nil
+		}
+	}
+	if n, err := strconv.Atoi(value); err == nil && n >= int(time.January) && n <= int(time.December) {
+		return time.Month(n), nil
+	}
+	return 0, fmt.Errorf("unknown month %q", value)
+}
+
+// mapSeparators returns the separators used to parse a map field: between
+// entries (envMapSeparator, default ","), between a key and its value
+// (envKeyValSeparator, default ":") and, for slice values, between the
+// elements of a value (envSeparator, default ";").  They must differ so an
+// entry can be split unambiguously.
+func mapSeparators(refType reflect.StructField) (entrySep, keyValSep, valueSep string, err error) {
+	entrySep, keyValSep, valueSep = ",", ":", ";"
+	if sep := refType.Tag.Get("envMapSeparator"); sep != "" {
+		entrySep = separatorEscapes.Replace(sep)
+	}
+	if sep := refType.Tag.Get("envKeyValSeparator"); sep != "" {
+		keyValSep = separatorEscapes.Replace(sep)
+	}
+	if sep := refType.Tag.Get("envSeparator"); sep != "" {
+		valueSep = separatorEscapes.Replace(sep)
+	}
+	seps := []string{entrySep, keyValSep}
+	if refType.Type.Elem().Kind() == reflect.Slice {
+		seps = append(seps, valueSep)
+	}
+	for i := range seps {
+		for j := range seps {
+			if i != j && strings.Contains(seps[i], seps[j]) {
+				return "", "", "", fmt.Errorf("map separators %q for %s collide", seps, refType.Name)
+			}
+		}
+	}
+	return entrySep, keyValSep, valueSep, nil
+}
+
+// handleMap parses a list of key/value entries such as "a:1,b:2" into a map
+// with string keys.  Each value is parsed like a field of the map's value
+// type, so map[string][]string accepts "a:x;y,b:z".
+func handleMap(field reflect.Value, value string, refType reflect.StructField, opts Options) error {
+	mapType := field.Type()
+	if !isMapKeyKind(mapType.Key().Kind()) {
+		return ErrUnsupportedType
+	}
+	if isSetType(mapType) {
+		return handleSet(field, value, refType, opts)
+	}
+	entrySep, keyValSep, valueSep, err := mapSeparators(refType)
+	if err != nil {
+		return err
+	}
+	// the value separator is passed on as envSeparator, along with only
+	// the tags that apply to each value
+	valueField := reflect.StructField{
+		Name: refType.Name,
+		Type: mapType.Elem(),
+		Tag:  reflect.StructTag("envSeparator:" + strconv.Quote(valueSep) + " " + string(mapValueTag(refType.Tag))),
+	}
+
+	m := reflect.MakeMap(mapType)
+	for _, entry := range strings.Split(value, entrySep) {
+		kv := strings.SplitN(entry, keyValSep, 2)
+		if len(kv) != 2 {
+			return newParseError(refType, entry, fmt.Errorf("invalid map entry: expected key%svalue", keyValSep))
+		}
+		key, err := parseMapKey(kv[0], mapType.Key(), refType, opts)
+		if err != nil {
+			return err
+		}
+		elem := reflect.New(mapType.Elem()).Elem()
+		if kv[1] == "" {
+			// an empty value leaves the zero value, as an empty variable
+			// leaves a field unset
+			m.SetMapIndex(key, elem)
+			continue
+		}
+		if err := set(elem, valueField, kv[1], opts); err != nil {
+			return err
+		}
+		m.SetMapIndex(key, elem)
+	}
+	field.Set(m)
+	return nil
+}
+
+// mapValueTags are the tags of a map field that apply to each of its values.
+// The others, such as envElemMaxLen or envParser, describe the field as a
+// whole and are not passed on.
+var mapValueTags = []string{
+	"envBool", "envTrue", "envFalse", "envCSV", "envDecode", "envDuration", "envExpr",
+	"envFormat", "envGrouping", "envInfer", "envLayouts", "envRate", "envRunes",
+	"envSort", "envTime", "envUnit", "envURL",
+}
+
+// mapValueTag returns the tags of a map field that apply to its values.
+func mapValueTag(tag reflect.StructTag) reflect.StructTag {
+	var parts []string
+	for _, name := range mapValueTags {
+		if value, ok := tag.Lookup(name); ok {
+			parts = append(parts, name+":"+strconv.Quote(value))
+		}
 	}
-	return errors.New(strings.Join(errorList, ". "))
+	return reflect.StructTag(strings.Join(parts, " "))
 }
 
-func get(field reflect.StructField, prefix string) (string, error) {
-	key := prefix + field.Tag.Get("env")
+func handleSlice(field reflect.Value, value string, refType reflect.StructField) error {
+	separator := tagSeparator(refType.Tag)
 
-	var envRequired = false
-	reqTag, hasRequiredTag := field.Tag.Lookup("required")
-	if hasRequiredTag {
-		var b bool
+	var splitData []string
+	if separator == "auto" {
+		splitData = splitAuto(value)
+	} else if strings.ToLower(refType.Tag.Get("envCSV")) == "true" {
 		var err error
-		if b, err = strconv.ParseBool(reqTag); err != nil {
-			// The value provided for the required tag is not a valid
-			// Boolean, so inform the user.
-			return "", fmt.Errorf("invalid required tag %q: %v", reqTag, err)
-		}
-		if b {
-			envRequired = true
+		if splitData, err = splitCSV(value, separator); err != nil {
+			return err
 		}
+	} else {
+		splitData = strings.Split(value, separator)
 	}
 
-	value, envFound := os.LookupEnv(key)
-	if !envFound && envRequired {
-		return "", fmt.Errorf("env var %s was missing and is required", key)
+	if limit := refType.Tag.Get("envElemMaxLen"); limit != "" {
+		maxLen, err := strconv.Atoi(limit)
+		if err != nil || maxLen < 0 {
+			return fmt.Errorf("invalid envElemMaxLen tag %q", limit)
+		}
+		for i, elem := range splitData {
+			if len(elem) > maxLen {
+				return &validationError{fmt.Errorf("element %d of %s is %d bytes long, exceeding envElemMaxLen %d", i, refType.Name, len(elem), maxLen)}
+			}
+		}
 	}
 
-	if !envFound {
-		// apply default if one exists
-		value = field.Tag.Get("envDefault")
+	// named slice types such as `type Tags []string` are matched by their
+	// element type and converted once parsed
+	var data interface{}
+	var err error
+	switch reflect.SliceOf(field.Type().Elem()) {
+	case sliceOfStrings:
+		data = splitData
+	case sliceOfInts:
+		data, err = parseInts(splitData)
+	case sliceOfInt64s:
+		data, err = parseInt64s(splitData)
+	case sliceOfUint64s:
+		data, err = parseUint64s(splitData)
+	case sliceOfFloat32s:
+		data, err = parseFloat32s(splitData)
+	case sliceOfFloat64s:
+		data, err = parseFloat64s(splitData)
+	case sliceOfBools:
+		data, err = parseBools(splitData, refType.Tag)
+	case sliceOfDurations:
+		data, err = parseDurations(splitData)
+	case sliceOfURLs:
+		data, err = parseUrls(splitData, refType.Tag)
+	case sliceOfAddresses:
+		data, err = parseAddresses(splitData)
+	case sliceOfIPNets:
+		data, err = parseIPNets(splitData, refType)
+	case sliceOfTimes:
+		data, err = parseTimes(splitData, refType.Tag.Get("envLayouts"))
+	default:
+		elemType := field.Type().Elem()
+		// Ensure we test *type as we can always address elements in a slice.
+		if elemType.Kind() == reflect.Ptr {
+			elemType = elemType.Elem()
+		}
+		if _, ok := reflect.New(elemType).Interface().(encoding.TextUnmarshaler); ok {
+			return parseTextUnmarshalers(field, splitData)
+		}
+		if isNumericKind(elemType.Kind()) && field.Type().Elem().Kind() != reflect.Ptr {
+			return parseNumbers(field, splitData)
+		}
+		if isPairType(field.Type().Elem()) {
+			return parsePairs(field, splitData, refType)
+		}
+		return ErrUnsupportedSliceType
+	}
+	if err != nil {
+		return err
 	}
+	field.Set(reflect.ValueOf(data).Convert(field.Type()))
+	return nil
+}
 
-	expandVar := field.Tag.Get("envExpand")
-	if strings.ToLower(expandVar) == "true" {
-		value = os.ExpandEnv(value)
+// sortSlice sorts the elements of a parsed slice for the envSort tag, which
+// is either "asc" or "desc".
+func sortSlice(field reflect.Value, order string) error {
+	var less func(i, j int) bool
+	switch field.Type().Elem().Kind() {
+	case reflect.String:
+		less = func(i, j int) bool { return field.Index(i).String() < field.Index(j).String() }
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		less = func(i, j int) bool { return field.Index(i).Int() < field.Index(j).Int() }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		less = func(i, j int) bool { return field.Index(i).Uint() < field.Index(j).Uint() }
+	case reflect.Float32, reflect.Float64:
+		less = func(i, j int) bool { return field.Index(i).Float() < field.Index(j).Float() }
+	default:
+		return fmt.Errorf("envSort is not supported for %s", field.Type())
 	}
 
-	return value, nil
+	switch strings.ToLower(order) {
+	case "asc":
+		sort.SliceStable(field.Interface(), less)
+	case "desc":
+		sort.SliceStable(field.Interface(), func(i, j int) bool { return less(j, i) })
+	default:
+		return fmt.Errorf("invalid envSort tag %q: expected asc or desc", order)
+	}
+	return nil
 }
 
-func set(field reflect.Value, refType reflect.StructField, value string, funcMap CustomParsers) error {
-	// use custom parser if configured for this type
-	parserFunc, ok := funcMap[refType.Type]
-	if ok {
-		val, err := parserFunc(value)
-		if err != nil {
-			return fmt.Errorf("Custom parser error: %v", err)
+// stripGrouping removes thousands separators from a number such as
+// 1,234,567.89.  Separators are optional, but when there are any every group
+// after the first must have three digits.
+func stripGrouping(value string) (string, error) {
+	integer, fraction := value, ""
+	if i := strings.Index(value, "."); i >= 0 {
+		integer, fraction = value[:i], value[i:]
+	}
+	sign := ""
+	if strings.HasPrefix(integer, "-") || strings.HasPrefix(integer, "+") {
+		sign, integer = integer[:1], integer[1:]
+	}
+	groups := strings.Split(integer, ",")
+	if len(groups) == 1 {
+		return value, nil
+	}
+	for i, group := range groups {
+		if group == "" || len(group) > 3 || (i > 0 && len(group) != 3) {
+			return "", fmt.Errorf("invalid digit grouping in %q", value)
 		}
-		field.Set(reflect.ValueOf(val))
-		return nil
 	}
+	return sign + strings.Join(groups, "") + fraction, nil
+}
 
-	if refType.Type == reflect.TypeOf(url.URL{}) {
-		u, err := url.Parse(value)
-		if err != nil {
-			return fmt.Errorf("Unable to complete URL parse: %v", err)
-		}
-		field.Set(reflect.ValueOf(*u))
-		return nil
+// isPairType reports whether t is a struct with string Key and Value fields,
+// such as struct{ Key, Value string }.  A slice of them holds key=value pairs
+// in the order they were given.
+func isPairType(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t.NumField() != 2 {
+		return false
 	}
+	key, hasKey := t.FieldByName("Key")
+	value, hasValue := t.FieldByName("Value")
+	return hasKey && hasValue && key.PkgPath == "" && value.PkgPath == "" &&
+		key.Type.Kind() == reflect.String && value.Type.Kind() == reflect.String
+}
 
-	// fall back to built-in parsers
-	switch field.Kind() {
-	case reflect.Slice:
-		separator := refType.Tag.Get("envSeparator")
-		return handleSlice(field, value, separator)
-	case reflect.String:
-		field.SetString(value)
-	case reflect.Bool:
-		bvalue, err := strconv.ParseBool(value)
-		if err != nil {
-			return err
-		}
-		field.SetBool(bvalue)
-	case reflect.Int:
-		intValue, err := strconv.ParseInt(value, 10, 32)
-		if err != nil {
-			return err
-		}
-		field.SetInt(intValue)
-	case reflect.Uint:
-		uintValue, err := strconv.ParseUint(value, 10, 32)
-		if err != nil {
-			return err
+// parsePairs splits each element on the envKeyValSeparator tag (default "=")
+// into the Key and Value fields of a pair.
+func parsePairs(field reflect.Value, data []string, refType reflect.StructField) error {
+	separator := "="
+	if sep := refType.Tag.Get("envKeyValSeparator"); sep != "" {
+		separator = separatorEscapes.Replace(sep)
+	}
+	slice := reflect.MakeSlice(field.Type(), len(data), len(data))
+	for i, v := range data {
+		kv := strings.SplitN(v, separator, 2)
+		if len(kv) != 2 {
+			return newParseError(refType, v, fmt.Errorf("invalid pair: expected key%svalue", separator))
 		}
-		field.SetUint(uintValue)
-	case reflect.Float32:
-		v, err := strconv.ParseFloat(value, 32)
+		slice.Index(i).FieldByName("Key").SetString(kv[0])
+		slice.Index(i).FieldByName("Value").SetString(kv[1])
+	}
+	field.Set(slice)
+	return nil
+}
+
+// handleSet parses a list such as "a,b,a" into a map with struct{} values,
+// split like a slice on envSeparator.  Repeated elements collapse into one.
+func handleSet(field reflect.Value, value string, refType reflect.StructField, opts Options) error {
+	mapType := field.Type()
+	m := reflect.MakeMap(mapType)
+	for _, elem := range strings.Split(value, tagSeparator(refType.Tag)) {
+		key, err := parseMapKey(elem, mapType.Key(), refType, opts)
 		if err != nil {
 			return err
 		}
-		field.SetFloat(v)
-	case reflect.Float64:
-		v, err := strconv.ParseFloat(value, 64)
-		if err != nil {
-			return err
+		m.SetMapIndex(key, reflect.New(mapType.Elem()).Elem())
+	}
+	field.Set(m)
+	return nil
+}
+
+// parseMapKey parses a map key of the given type, which has one of the
+// kinds accepted by isMapKeyKind.
+func parseMapKey(value string, keyType reflect.Type, refType reflect.StructField, opts Options) (reflect.Value, error) {
+	key := reflect.New(keyType).Elem()
+	if keyType.Kind() == reflect.String {
+		key.SetString(value)
+	} else if err := set(key, reflect.StructField{Name: refType.Name, Type: keyType}, value, opts); err != nil {
+		var parseErr *ParseError
+		if errors.As(err, &parseErr) {
+			err = parseErr.Err
 		}
-		field.Set(reflect.ValueOf(v))
-	case reflect.Int64:
-		if refType.Type.String() == "time.Duration" {
-			dValue, err := time.ParseDuration(value)
+		return key, newParseError(refType, value, fmt.Errorf("invalid map key: %v", err))
+	}
+	return key, nil
+}
+
+// isSetType reports whether t is a set, a map with struct{} values, which is
+// parsed from a list of its keys.
+func isSetType(t reflect.Type) bool {
+	return t.Elem().Kind() == reflect.Struct && t.Elem().NumField() == 0
+}
+
+// isMapKeyKind reports whether map keys of kind k can be parsed: strings and
+// the integer kinds that set parses.
+func isMapKeyKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.String, reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// isNumericKind reports whether parseNumbers can parse elements of kind k.
+func isNumericKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// parseNumbers fills a slice of any integer or float element kind, parsing
+// each element with the bit size of that kind so out of range values fail.
+func parseNumbers(field reflect.Value, data []string) error {
+	elemType := field.Type().Elem()
+	slice := reflect.MakeSlice(field.Type(), len(data), len(data))
+	for i, v := range data {
+		elem := slice.Index(i)
+		switch elemType.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(v, 10, elemType.Bits())
 			if err != nil {
 				return err
 			}
-			field.Set(reflect.ValueOf(dValue))
-		} else {
-			intValue, err := strconv.ParseInt(value, 10, 64)
+			elem.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n, err := strconv.ParseUint(v, 10, elemType.Bits())
 			if err != nil {
 				return err
 			}
-			field.SetInt(intValue)
-		}
-	case reflect.Uint64:
-		uintValue, err := strconv.ParseUint(value, 10, 64)
-		if err != nil {
-			return err
+			elem.SetUint(n)
+		default:
+			n, err := strconv.ParseFloat(v, elemType.Bits())
+			if err != nil {
+				return err
+			}
+			elem.SetFloat(n)
 		}
-		field.SetUint(uintValue)
-	default:
-		return handleTextUnmarshaler(field, value)
 	}
+	field.Set(slice)
 	return nil
 }
 
-func handleSlice(field reflect.Value, value, separator string) error {
-	if separator == "" {
-		separator = ","
+// handleIndexedSlice populates a slice of structs from index-prefixed env vars,
+// e.g. SERVERS_0_HOST, SERVERS_0_PORT, SERVERS_1_HOST for the key SERVERS.
+// The slice length is one more than the highest index found; missing indices
+// are parsed like any other element and so only receive their defaults.
+func handleIndexedSlice(field reflect.Value, key string, opts Options) error {
+	if field.Type().Elem().Kind() != reflect.Struct {
+		return ErrUnsupportedSliceType
 	}
 
-	splitData := strings.Split(value, separator)
+	count := maxIndex(key+"_", opts) + 1
+	if count == 0 {
+		return nil
+	}
 
-	switch field.Type() {
-	case sliceOfStrings:
-		field.Set(reflect.ValueOf(splitData))
-	case sliceOfInts:
-		intData, err := parseInts(splitData)
-		if err != nil {
-			return err
+	errs := &parseErrors{}
+	slice := reflect.MakeSlice(field.Type(), count, count)
+	for i := 0; i < count; i++ {
+		if err := doParse(slice.Index(i), fmt.Sprintf("%s_%d_", key, i), opts); err != nil {
+			errs.add(err)
 		}
-		field.Set(reflect.ValueOf(intData))
-	case sliceOfInt64s:
-		int64Data, err := parseInt64s(splitData)
-		if err != nil {
-			return err
+	}
+	field.Set(slice)
+	return errs.err()
+}
+
+// maxIndex returns the highest N for which a key named prefix + N + "_..."
+// can be looked up, or -1 if there is none.  The keys are those of the
+// overrides during ApplyOverrides, and otherwise those of every KeySource in
+// Options.Sources and of the environment.  The prefix is rewritten and
+// normalized the same way lookupEnv treats a key.
+func maxIndex(prefix string, opts Options) int {
+	var keys []string
+	if opts.overrides != nil {
+		for key := range opts.overrides {
+			keys = append(keys, key)
 		}
-		field.Set(reflect.ValueOf(int64Data))
-	case sliceOfUint64s:
-		uint64Data, err := parseUint64s(splitData)
-		if err != nil {
-			return err
+	} else {
+		prefix = rewriteKey(prefix, opts)
+		for _, source := range opts.Sources {
+			if lister, ok := source.(KeySource); ok {
+				keys = append(keys, lister.Keys()...)
+			}
 		}
-		field.Set(reflect.ValueOf(uint64Data))
-	case sliceOfFloat32s:
-		data, err := parseFloat32s(splitData)
-		if err != nil {
-			return err
+		keys = append(keys, environKeys()...)
+		if opts.NormalizeKey != nil {
+			prefix = opts.NormalizeKey(prefix)
+			for i, key := range keys {
+				keys[i] = opts.NormalizeKey(key)
+			}
 		}
-		field.Set(reflect.ValueOf(data))
-	case sliceOfFloat64s:
-		data, err := parseFloat64s(splitData)
-		if err != nil {
-			return err
+	}
+
+	max := -1
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
 		}
-		field.Set(reflect.ValueOf(data))
-	case sliceOfBools:
-		boolData, err := parseBools(splitData)
-		if err != nil {
-			return err
+		rest := key[len(prefix):]
+		sep := strings.Index(rest, "_")
+		if sep <= 0 {
+			continue
 		}
-		field.Set(reflect.ValueOf(boolData))
-	case sliceOfDurations:
-		durationData, err := parseDurations(splitData)
-		if err != nil {
-			return err
+		index, err := strconv.Atoi(rest[:sep])
+		if err != nil || index < 0 {
+			continue
 		}
-		field.Set(reflect.ValueOf(durationData))
-	case sliceOfURLs:
-		urlData, err := parseUrls(splitData)
-		if err != nil {
-			return err
+		if index > max {
+			max = index
 		}
-		field.Set(reflect.ValueOf(urlData))
+	}
+	return max
+}
+
+// separatorEscapes are the escape sequences interpreted in an envSeparator tag.
+// Tag values are already unquoted by reflect, so these only matter when the
+// backslash itself was escaped, as in envSeparator:"\\n" or in tags built by
+// code generators.
+var separatorEscapes = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\t`, "\t", `\r`, "\r")
+
+// tagSeparator returns the slice separator set by the envSeparator tag, with
+// escape sequences interpreted, or "," if no separator is set.
+func tagSeparator(tag reflect.StructTag) string {
+	separator := tag.Get("envSeparator")
+	if separator == "" {
+		return ","
+	}
+	return separatorEscapes.Replace(separator)
+}
+
+// splitAuto splits a value for envSeparator:"auto" on commas if it has any,
+// else on semicolons if it has any, and else on runs of whitespace.  Elements
+// are trimmed of surrounding whitespace, so "a, b" and "a; b" both give a
+// and b.
+func splitAuto(value string) []string {
+	var parts []string
+	switch {
+	case strings.Contains(value, ","):
+		parts = strings.Split(value, ",")
+	case strings.Contains(value, ";"):
+		parts = strings.Split(value, ";")
 	default:
-		elemType := field.Type().Elem()
-		// Ensure we test *type as we can always address elements in a slice.
-		if elemType.Kind() == reflect.Ptr {
-			elemType = elemType.Elem()
-		}
-		if _, ok := reflect.New(elemType).Interface().(encoding.TextUnmarshaler); !ok {
-			return ErrUnsupportedSliceType
-		}
-		return parseTextUnmarshalers(field, splitData)
+		return strings.Fields(value)
+	}
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+	}
+	return parts
+}
 
+// splitCSV splits a value as a single CSV record, so elements may be quoted
+// to contain the separator or, doubled, the quote character itself.
+func splitCSV(value, separator string) ([]string, error) {
+	comma, size := utf8.DecodeRuneInString(separator)
+	if size != len(separator) {
+		return nil, fmt.Errorf("invalid separator %q: envCSV requires a single character", separator)
 	}
-	return nil
+	r := csv.NewReader(strings.NewReader(value))
+	r.Comma = comma
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV value %q: %v", value, err)
+	}
+	if len(records) != 1 {
+		return nil, fmt.Errorf("invalid CSV value %q: expected a single record", value)
+	}
+	return records[0], nil
 }
 
 func handleTextUnmarshaler(field reflect.Value, value string) error {
@@ -389,11 +2887,38 @@ func parseFloat64s(data []string) ([]float64, error) {
 	return float64Slice, nil
 }
 
-func parseBools(data []string) ([]bool, error) {
+// parseBool parses a boolean according to the field's tags.  The words in
+// envTrue and envFalse are matched case-insensitively first.  Otherwise the
+// envBool mode applies: the default accepts anything strconv.ParseBool does;
+// "numeric" accepts only 0 and 1.
+func parseBool(value string, tag reflect.StructTag) (bool, error) {
+	if word := tag.Get("envTrue"); word != "" && strings.EqualFold(value, word) {
+		return true, nil
+	}
+	if word := tag.Get("envFalse"); word != "" && strings.EqualFold(value, word) {
+		return false, nil
+	}
+	mode := tag.Get("envBool")
+	switch strings.ToLower(mode) {
+	case "":
+		return strconv.ParseBool(value)
+	case "numeric":
+		switch value {
+		case "0":
+			return false, nil
+		case "1":
+			return true, nil
+		}
+		return false, fmt.Errorf("invalid numeric bool %q: expected 0 or 1", value)
+	}
+	return false, fmt.Errorf("invalid envBool tag %q", mode)
+}
+
+func parseBools(data []string, tag reflect.StructTag) ([]bool, error) {
 	boolSlice := make([]bool, 0, len(data))
 
 	for _, v := range data {
-		bvalue, err := strconv.ParseBool(v)
+		bvalue, err := parseBool(v, tag)
 		if err != nil {
 			return nil, err
 		}
@@ -417,39 +2942,143 @@ func parseDurations(data []string) ([]time.Duration, error) {
 	return durationSlice, nil
 }
 
-func parseUrls(data []string) ([]url.URL, error) {
+func parseUrls(data []string, tag reflect.StructTag) ([]url.URL, error) {
 	urlSlice := make([]url.URL, 0, len(data))
 
-	for _, v := range data {
+	for i, v := range data {
 		uvalue, err := url.Parse(v)
 		if err != nil {
 			return nil, err
 		}
+		if err := checkURL(uvalue, tag); err != nil {
+			return nil, fmt.Errorf("element %d: %w", i, err)
+		}
 
 		urlSlice = append(urlSlice, *uvalue)
 	}
 	return urlSlice, nil
 }
 
+// checkURL applies the envURL tag to a parsed URL.  The only mode,
+// "absolute", requires a scheme.
+func checkURL(u *url.URL, tag reflect.StructTag) error {
+	switch mode := tag.Get("envURL"); strings.ToLower(mode) {
+	case "":
+	case "absolute":
+		if !u.IsAbs() {
+			return &validationError{fmt.Errorf("invalid URL %q: expected an absolute URL", u)}
+		}
+	default:
+		return fmt.Errorf("invalid envURL tag %q", mode)
+	}
+	return nil
+}
+
+func parseAddresses(data []string) ([]mail.Address, error) {
+	addressSlice := make([]mail.Address, 0, len(data))
+
+	for _, v := range data {
+		addr, err := mail.ParseAddress(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %v", v, err)
+		}
+
+		addressSlice = append(addressSlice, *addr)
+	}
+	return addressSlice, nil
+}
+
+func parseIPNets(data []string, refType reflect.StructField) ([]net.IPNet, error) {
+	ipNetSlice := make([]net.IPNet, 0, len(data))
+
+	for _, v := range data {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(v))
+		if err != nil {
+			return nil, newParseError(refType, v, err)
+		}
+
+		ipNetSlice = append(ipNetSlice, *ipNet)
+	}
+	return ipNetSlice, nil
+}
+
+// timeLayouts maps the names of the layouts in the time package to the
+// layouts themselves, since some of them contain commas.
+var timeLayouts = map[string]string{
+	"ANSIC":       time.ANSIC,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"RFC850":      time.RFC850,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"Kitchen":     time.Kitchen,
+	"DateTime":    time.DateTime,
+	"DateOnly":    time.DateOnly,
+	"TimeOnly":    time.TimeOnly,
+}
+
+// parseTimes parses every element with the first of the comma-separated
+// layouts that matches it.  A layout is either the name of one in the time
+// package, such as DateOnly, or a layout string.  Without layouts the
+// elements are RFC 3339 times, as for a single time.Time.
+func parseTimes(data []string, layouts string) ([]time.Time, error) {
+	var candidates []string
+	for _, layout := range strings.Split(layouts, ",") {
+		if layout = strings.TrimSpace(layout); layout == "" {
+			continue
+		}
+		if named, ok := timeLayouts[layout]; ok {
+			layout = named
+		}
+		candidates = append(candidates, layout)
+	}
+
+	timeSlice := make([]time.Time, 0, len(data))
+	for _, v := range data {
+		var t time.Time
+		if len(candidates) == 0 {
+			if err := t.UnmarshalText([]byte(v)); err != nil {
+				return nil, err
+			}
+			timeSlice = append(timeSlice, t)
+			continue
+		}
+		v = strings.TrimSpace(v)
+		matched := false
+		for _, layout := range candidates {
+			var err error
+			if t, err = time.Parse(layout, v); err == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("time %q matches none of the layouts %q", v, layouts)
+		}
+		timeSlice = append(timeSlice, t)
+	}
+	return timeSlice, nil
+}
+
 func parseTextUnmarshalers(field reflect.Value, data []string) error {
 	s := len(data)
 	elemType := field.Type().Elem()
-	slice := reflect.MakeSlice(reflect.SliceOf(elemType), s, s)
+	slice := reflect.MakeSlice(field.Type(), s, s)
 	for i, v := range data {
-		sv := slice.Index(i)
-		kind := sv.Kind()
-		if kind == reflect.Ptr {
-			sv = reflect.New(elemType.Elem())
+		// elements of a slice are addressable, so a value element is
+		// unmarshaled in place; a pointer element is allocated first
+		elem := slice.Index(i)
+		if elemType.Kind() == reflect.Ptr {
+			elem.Set(reflect.New(elemType.Elem()))
 		} else {
-			sv = sv.Addr()
+			elem = elem.Addr()
 		}
-		tm := sv.Interface().(encoding.TextUnmarshaler)
+		tm := elem.Interface().(encoding.TextUnmarshaler)
 		if err := tm.UnmarshalText([]byte(v)); err != nil {
 			return err
 		}
-		if kind == reflect.Ptr {
-			slice.Index(i).Set(sv)
-		}
 	}
 
 	field.Set(slice)
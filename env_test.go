@@ -1,9 +1,17 @@
 package env
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	imagecolor "image/color"
+	"log/slog"
+	"math/big"
+	"net"
 	"net/http"
+	"net/mail"
 	"net/url"
 	"os"
 	"reflect"
@@ -715,3 +723,3160 @@ func ExampleParse() {
 	fmt.Println(cfg)
 	// Output: {/tmp/fakehome 3000 false}
 }
+
+type ServerConfig struct {
+	Host string `env:"HOST"`
+	Port int    `env:"PORT" envDefault:"80"`
+}
+
+type indexedConfig struct {
+	Servers []ServerConfig `env:"SERVERS" envIndexed:"true"`
+}
+
+func TestIndexedSliceNone(t *testing.T) {
+	cfg := indexedConfig{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Nil(t, cfg.Servers)
+}
+
+func TestIndexedSliceOne(t *testing.T) {
+	os.Setenv("SERVERS_0_HOST", "alpha")
+	os.Setenv("SERVERS_0_PORT", "8080")
+	defer os.Clearenv()
+
+	cfg := indexedConfig{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, []ServerConfig{{Host: "alpha", Port: 8080}}, cfg.Servers)
+}
+
+func TestIndexedSliceTwo(t *testing.T) {
+	os.Setenv("APP_SERVERS_0_HOST", "alpha")
+	os.Setenv("APP_SERVERS_1_HOST", "beta")
+	os.Setenv("APP_SERVERS_1_PORT", "9090")
+	defer os.Clearenv()
+
+	cfg := indexedConfig{}
+	assert.NoError(t, ParseWithPrefix(&cfg, "APP_"))
+	assert.Equal(t, []ServerConfig{{Host: "alpha", Port: 80}, {Host: "beta", Port: 9090}}, cfg.Servers)
+}
+
+func TestIndexedSliceGap(t *testing.T) {
+	os.Setenv("SERVERS_0_HOST", "alpha")
+	os.Setenv("SERVERS_2_HOST", "gamma")
+	defer os.Clearenv()
+
+	cfg := indexedConfig{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, []ServerConfig{{Host: "alpha", Port: 80}, {Port: 80}, {Host: "gamma", Port: 80}}, cfg.Servers)
+}
+
+func TestIndexedSliceInvalid(t *testing.T) {
+	os.Setenv("SERVERS_0_PORT", "not-a-port")
+	defer os.Clearenv()
+
+	cfg := indexedConfig{}
+	assert.Error(t, Parse(&cfg))
+}
+
+func TestIndexedSliceCollectsErrors(t *testing.T) {
+	os.Setenv("SERVERS_0_PORT", "not-a-port")
+	os.Setenv("SERVERS_1_HOST", "beta")
+	os.Setenv("SERVERS_2_PORT", "also-bad")
+	defer os.Clearenv()
+
+	cfg := indexedConfig{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `"not-a-port"`)
+	assert.Contains(t, err.Error(), `"also-bad"`)
+	assert.Equal(t, "beta", cfg.Servers[1].Host)
+}
+
+func TestNumericBool(t *testing.T) {
+	type config struct {
+		Enabled bool   `env:"ENABLED" envBool:"numeric"`
+		Flags   []bool `env:"FLAGS" envBool:"numeric"`
+	}
+	os.Setenv("ENABLED", "1")
+	os.Setenv("FLAGS", "1,0,1")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, true, cfg.Enabled)
+	assert.Equal(t, []bool{true, false, true}, cfg.Flags)
+}
+
+func TestNumericBoolRejectsWords(t *testing.T) {
+	type config struct {
+		Enabled bool `env:"ENABLED" envBool:"numeric"`
+	}
+	os.Setenv("ENABLED", "true")
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.False(t, cfg.Enabled)
+}
+
+func TestNumericBoolSliceRejectsWords(t *testing.T) {
+	type config struct {
+		Flags []bool `env:"FLAGS" envBool:"numeric"`
+	}
+	os.Setenv("FLAGS", "1,false")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.Error(t, Parse(&cfg))
+}
+
+func TestInvalidBoolTag(t *testing.T) {
+	type config struct {
+		Enabled bool `env:"ENABLED" envBool:"yesno"`
+	}
+	os.Setenv("ENABLED", "1")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.Error(t, Parse(&cfg))
+}
+
+func TestWeekdayAndMonth(t *testing.T) {
+	type config struct {
+		Day        time.Weekday `env:"DAY"`
+		DayNum     time.Weekday `env:"DAY_NUM"`
+		Month      time.Month   `env:"MONTH"`
+		MonthNum   time.Month   `env:"MONTH_NUM"`
+		DayDefault time.Weekday `env:"DAY_DEFAULT" envDefault:"friday"`
+	}
+	os.Setenv("DAY", "Monday")
+	os.Setenv("DAY_NUM", "0")
+	os.Setenv("MONTH", "MARCH")
+	os.Setenv("MONTH_NUM", "12")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, time.Monday, cfg.Day)
+	assert.Equal(t, time.Sunday, cfg.DayNum)
+	assert.Equal(t, time.March, cfg.Month)
+	assert.Equal(t, time.December, cfg.MonthNum)
+	assert.Equal(t, time.Friday, cfg.DayDefault)
+}
+
+func TestInvalidWeekday(t *testing.T) {
+	type config struct {
+		Day time.Weekday `env:"DAY"`
+	}
+	os.Setenv("DAY", "Funday")
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Equal(t, `env var DAY: unable to parse "Funday" into field Day of type time.Weekday: unknown weekday "Funday"`, err.Error())
+}
+
+func TestInvalidMonth(t *testing.T) {
+	type config struct {
+		Month time.Month `env:"MONTH"`
+	}
+	os.Setenv("MONTH", "13")
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Equal(t, `env var MONTH: unable to parse "13" into field Month of type time.Month: unknown month "13"`, err.Error())
+}
+
+func TestDeprecatedKey(t *testing.T) {
+	type config struct {
+		Host string `env:"DB_HOST" envDeprecated:"DATABASE_HOST" required:"true"`
+	}
+	os.Setenv("DATABASE_HOST", "db.local")
+	defer os.Clearenv()
+
+	var oldKeys, newKeys []string
+	OnDeprecatedKey = func(field reflect.StructField, oldKey, newKey string) {
+		oldKeys = append(oldKeys, oldKey)
+		newKeys = append(newKeys, newKey)
+	}
+	defer func() { OnDeprecatedKey = nil }()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, "db.local", cfg.Host)
+	assert.Equal(t, []string{"DATABASE_HOST"}, oldKeys)
+	assert.Equal(t, []string{"DB_HOST"}, newKeys)
+}
+
+func TestDeprecatedKeyPrimaryWins(t *testing.T) {
+	type config struct {
+		Host string `env:"DB_HOST" envDeprecated:"DATABASE_HOST"`
+	}
+	os.Setenv("APP_DB_HOST", "new.local")
+	os.Setenv("APP_DATABASE_HOST", "old.local")
+	defer os.Clearenv()
+
+	called := false
+	OnDeprecatedKey = func(reflect.StructField, string, string) { called = true }
+	defer func() { OnDeprecatedKey = nil }()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithPrefix(&cfg, "APP_"))
+	assert.Equal(t, "new.local", cfg.Host)
+	assert.False(t, called)
+}
+
+func TestSQLNullTypes(t *testing.T) {
+	type config struct {
+		Name      sql.NullString  `env:"NAME"`
+		Count     sql.NullInt64   `env:"COUNT"`
+		Ratio     sql.NullFloat64 `env:"RATIO"`
+		Unset     sql.NullString  `env:"UNSET"`
+		UnsetInt  sql.NullInt64   `env:"UNSET_INT"`
+		Defaulted sql.NullInt64   `env:"DEFAULTED" envDefault:"7"`
+	}
+	os.Setenv("NAME", "widget")
+	os.Setenv("COUNT", "42")
+	os.Setenv("RATIO", "0.5")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, sql.NullString{String: "widget", Valid: true}, cfg.Name)
+	assert.Equal(t, sql.NullInt64{Int64: 42, Valid: true}, cfg.Count)
+	assert.Equal(t, sql.NullFloat64{Float64: 0.5, Valid: true}, cfg.Ratio)
+	assert.False(t, cfg.Unset.Valid)
+	assert.False(t, cfg.UnsetInt.Valid)
+	assert.Equal(t, sql.NullInt64{Int64: 7, Valid: true}, cfg.Defaulted)
+}
+
+func TestSQLNullInt64Invalid(t *testing.T) {
+	type config struct {
+		Count sql.NullInt64 `env:"COUNT"`
+	}
+	os.Setenv("COUNT", "lots")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.Error(t, Parse(&cfg))
+	assert.False(t, cfg.Count.Valid)
+}
+
+type tlsConfig struct {
+	Cert       string  `env:"cert"`
+	Key        string  `env:"key"`
+	MinVersion float64 `env:"min"`
+}
+
+func TestInlineStruct(t *testing.T) {
+	type config struct {
+		TLS tlsConfig `env:"TLS" envInline:"true"`
+	}
+	os.Setenv("TLS", "cert=/a,key=/b,min=1.2")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, tlsConfig{Cert: "/a", Key: "/b", MinVersion: 1.2}, cfg.TLS)
+}
+
+func TestInlineStructUnknownKey(t *testing.T) {
+	type config struct {
+		TLS tlsConfig `env:"TLS" envInline:"true"`
+	}
+	os.Setenv("TLS", "cert=/a,ca=/c")
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Equal(t, `unknown inline key "ca" for TLS`, err.Error())
+}
+
+func TestInlineStructMalformedPair(t *testing.T) {
+	type config struct {
+		TLS tlsConfig `env:"TLS" envInline:"true" envSeparator:";"`
+	}
+	os.Setenv("TLS", "cert=/a;key")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.Error(t, Parse(&cfg))
+}
+
+func TestStrictUnsupportedTypeUnset(t *testing.T) {
+	type config struct {
+		Name  string   `env:"NAME"`
+		Votes chan int `env:"VOTES"`
+	}
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+
+	err := ParseWithOptions(&cfg, Options{Strict: true})
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrUnsupportedType))
+	assert.Equal(t, "field Votes: Type is not supported", err.Error())
+}
+
+func TestStrictUnsupportedNestedType(t *testing.T) {
+	type inner struct {
+		Client http.Client `env:"CLIENT"`
+	}
+	type config struct {
+		Inner *inner
+	}
+
+	cfg := config{Inner: &inner{}}
+	err := ParseWithOptions(&cfg, Options{Strict: true})
+	assert.True(t, errors.Is(err, ErrUnsupportedType))
+	assert.Contains(t, err.Error(), "Client")
+}
+
+func TestStrictSupportedTypes(t *testing.T) {
+	os.Setenv("PORT", "8080")
+	defer os.Clearenv()
+
+	cfg := Config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Equal(t, 8080, cfg.Port)
+}
+
+func TestStrictCustomParser(t *testing.T) {
+	type foo struct {
+		name string
+	}
+	type config struct {
+		Var foo `env:"VAR"`
+	}
+
+	cfg := config{}
+	assert.Error(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.NoError(t, ParseWithOptions(&cfg, Options{
+		Strict: true,
+		Parsers: CustomParsers{
+			reflect.TypeOf(foo{}): func(v string) (interface{}, error) { return foo{name: v}, nil },
+		},
+	}))
+}
+
+func TestResolvers(t *testing.T) {
+	type config struct {
+		Password string `env:"PASSWORD"`
+		Port     int    `env:"PORT" envDefault:"test://port"`
+		Endpoint string `env:"ENDPOINT"`
+	}
+	os.Setenv("PASSWORD", "test://vault/db")
+	os.Setenv("ENDPOINT", "other://host/path")
+	defer os.Clearenv()
+
+	var requested []string
+	cfg := config{}
+	err := ParseWithOptions(&cfg, Options{
+		Resolvers: map[string]func(string) (string, error){
+			"test": func(uri string) (string, error) {
+				requested = append(requested, uri)
+				if uri == "test://port" {
+					return "5432", nil
+				}
+				return "hunter2", nil
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "hunter2", cfg.Password)
+	assert.Equal(t, 5432, cfg.Port)
+	assert.Equal(t, "other://host/path", cfg.Endpoint)
+	assert.Equal(t, []string{"test://vault/db", "test://port"}, requested)
+}
+
+func TestResolverError(t *testing.T) {
+	type config struct {
+		Password string `env:"PASSWORD"`
+	}
+	os.Setenv("PASSWORD", "test://vault/missing")
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := ParseWithOptions(&cfg, Options{
+		Resolvers: map[string]func(string) (string, error){
+			"test": func(string) (string, error) { return "", errors.New("not found") },
+		},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, `unable to resolve "test://vault/missing": not found`, err.Error())
+}
+
+type color int
+
+const (
+	red color = iota
+	green
+)
+
+func (c color) String() string {
+	return [...]string{"red", "green"}[c]
+}
+
+func TestEnumRegistry(t *testing.T) {
+	type config struct {
+		Primary   color `env:"PRIMARY"`
+		Secondary color `env:"SECONDARY" envDefault:"green"`
+	}
+	os.Setenv("PRIMARY", "red")
+	defer os.Clearenv()
+
+	enums := EnumRegistry{
+		reflect.TypeOf(red): {red.String(): red, green.String(): green},
+	}
+
+	cfg := config{Primary: green}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Enums: enums}))
+	assert.Equal(t, red, cfg.Primary)
+	assert.Equal(t, green, cfg.Secondary)
+
+	os.Setenv("PRIMARY", "blue")
+	err := ParseWithOptions(&cfg, Options{Enums: enums})
+	assert.Error(t, err)
+	assert.Equal(t, `invalid value "blue" for env.color: expected one of green, red`, err.Error())
+}
+
+func TestParseErrorOverflow(t *testing.T) {
+	type config struct {
+		Port  int    `env:"PORT"`
+		Count uint64 `env:"COUNT"`
+	}
+	os.Setenv("APP_PORT", "99999999999")
+	os.Setenv("APP_COUNT", "-1")
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := ParseWithPrefix(&cfg, "APP_")
+	assert.Error(t, err)
+	assert.Equal(t, `env var APP_PORT: unable to parse "99999999999" into field Port of type int: `+
+		`strconv.ParseInt: parsing "99999999999": value out of range. `+
+		`env var APP_COUNT: unable to parse "-1" into field Count of type uint64: `+
+		`strconv.ParseUint: parsing "-1": invalid syntax`, err.Error())
+}
+
+func TestParseErrorUnwrap(t *testing.T) {
+	type config struct {
+		Port int `env:"PORT"`
+	}
+
+	os.Setenv("PORT", "99999999999999999999")
+	defer os.Clearenv()
+
+	err := Parse(&config{})
+	var parseErr *ParseError
+	assert.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, "PORT", parseErr.Key)
+	assert.Equal(t, "Port", parseErr.Field)
+	assert.Equal(t, "99999999999999999999", parseErr.Value)
+	assert.True(t, errors.Is(err, strconv.ErrRange))
+}
+
+func TestNestedPointerErrorsAggregated(t *testing.T) {
+	type config struct {
+		Inner *InnerStruct
+		Port  int `env:"PORT"`
+	}
+	os.Setenv("innernum", "-547")
+	os.Setenv("PORT", "not-a-port")
+	defer os.Clearenv()
+
+	cfg := config{Inner: &InnerStruct{}}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "env var innernum:")
+	assert.Contains(t, err.Error(), "env var PORT:")
+}
+
+func TestUnitValues(t *testing.T) {
+	type config struct {
+		Cache    int64   `env:"CACHE" envUnit:"bytes"`
+		Memory   uint64  `env:"MEMORY" envUnit:"bytes"`
+		Plain    int     `env:"PLAIN" envUnit:"bytes"`
+		Ratio    float64 `env:"RATIO" envUnit:"percent"`
+		Percent  int     `env:"PERCENT" envUnit:"percent"`
+		Fraction int64   `env:"FRACTION" envUnit:"bytes"`
+	}
+	os.Setenv("CACHE", "512MB")
+	os.Setenv("MEMORY", "2GiB")
+	os.Setenv("PLAIN", "1024")
+	os.Setenv("RATIO", "75%")
+	os.Setenv("PERCENT", "75%")
+	os.Setenv("FRACTION", "1.5KiB")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, int64(512000000), cfg.Cache)
+	assert.Equal(t, uint64(2147483648), cfg.Memory)
+	assert.Equal(t, 1024, cfg.Plain)
+	assert.Equal(t, 0.75, cfg.Ratio)
+	assert.Equal(t, 75, cfg.Percent)
+	assert.Equal(t, int64(1536), cfg.Fraction)
+}
+
+func TestUnitUnknownSuffix(t *testing.T) {
+	type config struct {
+		Cache int64 `env:"CACHE" envUnit:"bytes"`
+	}
+	os.Setenv("CACHE", "5XB")
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Equal(t, `unknown byte size suffix "XB" in "5XB"`, err.Error())
+}
+
+func TestUnitInvalidPercent(t *testing.T) {
+	type config struct {
+		Ratio float64 `env:"RATIO" envUnit:"percent"`
+	}
+	os.Setenv("RATIO", "0.75")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.Error(t, Parse(&cfg))
+}
+
+func TestUnitInvalidTag(t *testing.T) {
+	type config struct {
+		Cache int64 `env:"CACHE" envUnit:"furlongs"`
+	}
+	os.Setenv("CACHE", "5")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.Error(t, Parse(&cfg))
+}
+
+type embeddedBase struct {
+	Inner  string `env:"innervar"`
+	Number uint   `env:"innernum"`
+}
+
+func TestParsesEnvEmbeddedUnexportedType(t *testing.T) {
+	type config struct {
+		embeddedBase
+		Name string `env:"NAME"`
+	}
+	os.Setenv("innervar", "someinnervalue")
+	os.Setenv("innernum", "7")
+	os.Setenv("NAME", "outer")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, "someinnervalue", cfg.Inner)
+	assert.Equal(t, uint(7), cfg.Number)
+	assert.Equal(t, "outer", cfg.Name)
+}
+
+func TestParsesEnvEmbeddedPointer(t *testing.T) {
+	type config struct {
+		*BaseStruct
+		*embeddedBase
+	}
+	os.Setenv("innervar", "someinnervalue")
+	defer os.Clearenv()
+
+	cfg := config{BaseStruct: &BaseStruct{}, embeddedBase: &embeddedBase{}}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, "someinnervalue", cfg.BaseStruct.Inner)
+	assert.Equal(t, "someinnervalue", cfg.embeddedBase.Inner)
+}
+
+func TestSkipsUnexportedTaggedField(t *testing.T) {
+	type config struct {
+		hidden string `env:"HIDDEN"`
+		Shown  string `env:"SHOWN"`
+	}
+	os.Setenv("HIDDEN", "secret")
+	os.Setenv("SHOWN", "visible")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Empty(t, cfg.hidden)
+	assert.Equal(t, "visible", cfg.Shown)
+}
+
+func TestPlan(t *testing.T) {
+	type config struct {
+		Host    string        `env:"HOST" envDefault:"localhost"`
+		Port    int           `env:"PORT"`
+		Timeout time.Duration `env:"TIMEOUT"`
+		Tags    []string      `env:"TAGS"`
+		Unset   string        `env:"UNSET"`
+		Inner   *InnerStruct
+	}
+	os.Setenv("APP_PORT", "8080")
+	os.Setenv("APP_TIMEOUT", "5s")
+	os.Setenv("APP_TAGS", "a,b")
+	os.Setenv("APP_innervar", "nested")
+	defer os.Clearenv()
+
+	cfg := config{Port: 1, Inner: &InnerStruct{Inner: "before"}}
+	plan, err := Plan(&cfg, Options{Prefix: "APP_"})
+	assert.NoError(t, err)
+	assert.Equal(t, []PlannedSet{
+		{Field: "Host", Key: "APP_HOST", Raw: "localhost", Value: "localhost"},
+		{Field: "Port", Key: "APP_PORT", Raw: "8080", Value: 8080},
+		{Field: "Timeout", Key: "APP_TIMEOUT", Raw: "5s", Value: 5 * time.Second},
+		{Field: "Tags", Key: "APP_TAGS", Raw: "a,b", Value: []string{"a", "b"}},
+		{Field: "Inner", Key: "APP_innervar", Raw: "nested", Value: "nested"},
+	}, plan)
+
+	assert.Equal(t, config{Port: 1, Inner: &InnerStruct{Inner: "before"}}, cfg)
+}
+
+func TestPlanIndexedSliceUntouched(t *testing.T) {
+	os.Setenv("SERVERS_0_HOST", "alpha")
+	defer os.Clearenv()
+
+	cfg := indexedConfig{}
+	plan, err := Plan(&cfg, Options{})
+	assert.NoError(t, err)
+	assert.Nil(t, cfg.Servers)
+	assert.Equal(t, []PlannedSet{
+		{Field: "Host", Key: "SERVERS_0_HOST", Raw: "alpha", Value: "alpha"},
+		{Field: "Port", Key: "SERVERS_0_PORT", Raw: "80", Value: 80},
+	}, plan)
+}
+
+func TestPlanQuiet(t *testing.T) {
+	type config struct {
+		Host    string `env:"HOST" envDeprecated:"HOSTNAME"`
+		Workers int    `env:"WORKERS" envDeprecatedField:"use CONCURRENCY instead"`
+	}
+	os.Setenv("HOSTNAME", "old")
+	os.Setenv("WORKERS", "4")
+	defer os.Clearenv()
+
+	called := false
+	OnDeprecatedKey = func(reflect.StructField, string, string) { called = true }
+	OnDeprecatedField = func(reflect.StructField, string, string) { called = true }
+	defer func() { OnDeprecatedKey, OnDeprecatedField = nil, nil }()
+
+	plan, err := Plan(&config{}, Options{})
+	assert.NoError(t, err)
+	assert.Len(t, plan, 2)
+	assert.False(t, called)
+}
+
+func TestPlanError(t *testing.T) {
+	os.Setenv("PORT", "not-a-port")
+	defer os.Clearenv()
+
+	cfg := Config{}
+	plan, err := Plan(&cfg, Options{})
+	assert.Error(t, err)
+	assert.Nil(t, plan)
+}
+
+func TestExprValues(t *testing.T) {
+	type config struct {
+		Seconds int           `env:"SECONDS" envExpr:"true"`
+		Total   int64         `env:"TOTAL" envExpr:"true"`
+		Timeout time.Duration `env:"TIMEOUT" envExpr:"true"`
+	}
+	os.Setenv("SECONDS", "60*60")
+	os.Setenv("TOTAL", "(1+2)*3")
+	os.Setenv("TIMEOUT", "2*60")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, 3600, cfg.Seconds)
+	assert.Equal(t, int64(9), cfg.Total)
+	assert.Equal(t, 2*time.Minute, cfg.Timeout)
+}
+
+func TestExprMalformed(t *testing.T) {
+	type config struct {
+		Seconds int `env:"SECONDS" envExpr:"true"`
+	}
+	os.Setenv("SECONDS", "60*(60")
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Equal(t, `invalid expression "60*(60": missing closing parenthesis`, err.Error())
+}
+
+func TestFuncRegistry(t *testing.T) {
+	type strategy func(int) int
+	type config struct {
+		Strategy strategy `env:"STRATEGY"`
+		Fallback strategy `env:"FALLBACK" envDefault:"double"`
+	}
+	funcs := FuncRegistry{
+		reflect.TypeOf(strategy(nil)): {
+			"double": strategy(func(n int) int { return n * 2 }),
+			"square": strategy(func(n int) int { return n * n }),
+		},
+	}
+	os.Setenv("STRATEGY", "square")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Funcs: funcs}))
+	assert.Equal(t, 9, cfg.Strategy(3))
+	assert.Equal(t, 6, cfg.Fallback(3))
+
+	os.Setenv("STRATEGY", "cube")
+	err := ParseWithOptions(&cfg, Options{Funcs: funcs})
+	assert.Error(t, err)
+	assert.Equal(t, `unknown function "cube" for Strategy: expected one of double, square`, err.Error())
+}
+
+func TestFuncFieldNotRegistered(t *testing.T) {
+	type config struct {
+		Strategy func(int) int `env:"STRATEGY"`
+	}
+	os.Setenv("STRATEGY", "square")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.Equal(t, ErrUnsupportedType, Parse(&cfg))
+}
+
+func TestCSVSlice(t *testing.T) {
+	type config struct {
+		List   []string `env:"LIST" envCSV:"true"`
+		Quotes []string `env:"QUOTES" envCSV:"true"`
+		Semi   []string `env:"SEMI" envCSV:"true" envSeparator:";"`
+		Plain  []string `env:"PLAIN"`
+	}
+	os.Setenv("LIST", `"a,b",c`)
+	os.Setenv("QUOTES", `"say ""hi""",x`)
+	os.Setenv("SEMI", `"a;b";c,d`)
+	os.Setenv("PLAIN", `"a,b",c`)
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, []string{"a,b", "c"}, cfg.List)
+	assert.Equal(t, []string{`say "hi"`, "x"}, cfg.Quotes)
+	assert.Equal(t, []string{"a;b", "c,d"}, cfg.Semi)
+	assert.Equal(t, []string{`"a`, `b"`, "c"}, cfg.Plain)
+}
+
+func TestCSVSliceErrors(t *testing.T) {
+	type config struct {
+		List []string `env:"LIST" envCSV:"true"`
+		Wide []string `env:"WIDE" envCSV:"true" envSeparator:"||"`
+	}
+	os.Setenv("LIST", `"a,b`)
+	os.Setenv("WIDE", "a||b")
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid CSV value "\"a,b"`)
+	assert.Contains(t, err.Error(), `invalid separator "||"`)
+}
+
+func TestLocation(t *testing.T) {
+	type config struct {
+		UTC   *time.Location `env:"UTC_ZONE"`
+		Local *time.Location `env:"ZONE" envDefault:"America/New_York"`
+		Unset *time.Location `env:"UNSET_ZONE"`
+	}
+	os.Setenv("UTC_ZONE", "UTC")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, time.UTC, cfg.UTC)
+	assert.Equal(t, "America/New_York", cfg.Local.String())
+	assert.Nil(t, cfg.Unset)
+}
+
+func TestInvalidLocation(t *testing.T) {
+	type config struct {
+		Zone *time.Location `env:"ZONE"`
+	}
+	os.Setenv("ZONE", "Mars/Phobos")
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `env var ZONE: unable to parse "Mars/Phobos" into field Zone of type *time.Location: unknown time zone`)
+	assert.Nil(t, cfg.Zone)
+}
+
+func TestSizedNumberSlices(t *testing.T) {
+	type config struct {
+		Int8s   []int8   `env:"INT8S"`
+		Uint16s []uint16 `env:"UINT16S"`
+		Int32s  []int32  `env:"INT32S"`
+		Uints   []uint   `env:"UINTS"`
+	}
+	os.Setenv("INT8S", "-128,0,127")
+	os.Setenv("UINT16S", "0,65535")
+	os.Setenv("INT32S", "-2147483648,2147483647")
+	os.Setenv("UINTS", "1,2")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, []int8{-128, 0, 127}, cfg.Int8s)
+	assert.Equal(t, []uint16{0, 65535}, cfg.Uint16s)
+	assert.Equal(t, []int32{-2147483648, 2147483647}, cfg.Int32s)
+	assert.Equal(t, []uint{1, 2}, cfg.Uints)
+}
+
+func TestSizedNumberSliceOverflow(t *testing.T) {
+	type config struct {
+		Int8s []int8 `env:"INT8S"`
+	}
+	os.Setenv("INT8S", "1,128")
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Equal(t, `strconv.ParseInt: parsing "128": value out of range`, err.Error())
+	assert.Nil(t, cfg.Int8s)
+}
+
+func TestRateValues(t *testing.T) {
+	type config struct {
+		PerSecond float64       `env:"PER_SECOND" envRate:"true"`
+		PerMinute float64       `env:"PER_MINUTE" envRate:"true"`
+		Interval  time.Duration `env:"INTERVAL" envRate:"true"`
+		Slow      time.Duration `env:"SLOW" envRate:"true"`
+	}
+	os.Setenv("PER_SECOND", "100/s")
+	os.Setenv("PER_MINUTE", "60/m")
+	os.Setenv("INTERVAL", "100/s")
+	os.Setenv("SLOW", "2/h")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, 100.0, cfg.PerSecond)
+	assert.Equal(t, 1.0, cfg.PerMinute)
+	assert.Equal(t, 10*time.Millisecond, cfg.Interval)
+	assert.Equal(t, 30*time.Minute, cfg.Slow)
+}
+
+func TestRateMalformed(t *testing.T) {
+	type config struct {
+		Rate float64 `env:"RATE" envRate:"true"`
+	}
+	defer os.Clearenv()
+
+	for _, value := range []string{"100", "100/d", "fast/s", "0/s"} {
+		os.Setenv("RATE", value)
+		cfg := config{}
+		assert.Error(t, Parse(&cfg), value)
+	}
+}
+
+func TestMustParse(t *testing.T) {
+	os.Setenv("PORT", "8080")
+	defer os.Clearenv()
+
+	cfg := Config{}
+	assert.NotPanics(t, func() { MustParse(&cfg) })
+	assert.Equal(t, 8080, cfg.Port)
+}
+
+func TestMustParsePanics(t *testing.T) {
+	type config struct {
+		Host string `env:"HOST" required:"true"`
+		Port int    `env:"PORT"`
+	}
+	os.Setenv("APP_PORT", "not-a-port")
+	defer os.Clearenv()
+
+	var msg interface{}
+	func() {
+		defer func() { msg = recover() }()
+		MustParseWithOptions(&config{}, Options{Prefix: "APP_"})
+	}()
+	assert.NotNil(t, msg)
+	assert.Contains(t, msg, "missing required env var: APP_HOST")
+	assert.Contains(t, msg, `env var APP_PORT: unable to parse "not-a-port"`)
+}
+
+func TestEscapedSeparators(t *testing.T) {
+	type config struct {
+		Lines     []string `env:"LINES" envSeparator:"\n"`
+		Tabs      []int    `env:"TABS" envSeparator:"\t"`
+		CRLF      []string `env:"CRLF" envSeparator:"\r\n"`
+		Backslash []string `env:"BACKSLASH" envSeparator:"\\"`
+		Escaped   []string `env:"ESCAPED" envSeparator:"\\n"`
+		Tabbed    []string `env:"TABBED" envSeparator:"\\t"`
+	}
+	os.Setenv("LINES", "first line\nsecond line\nthird")
+	os.Setenv("TABS", "1\t2\t3")
+	os.Setenv("CRLF", "a\r\nb")
+	os.Setenv("BACKSLASH", `a\b\c`)
+	os.Setenv("ESCAPED", "x\ny")
+	os.Setenv("TABBED", "x\ty")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, []string{"first line", "second line", "third"}, cfg.Lines)
+	assert.Equal(t, []int{1, 2, 3}, cfg.Tabs)
+	assert.Equal(t, []string{"a", "b"}, cfg.CRLF)
+	assert.Equal(t, []string{"a", "b", "c"}, cfg.Backslash)
+	assert.Equal(t, []string{"x", "y"}, cfg.Escaped)
+	assert.Equal(t, []string{"x", "y"}, cfg.Tabbed)
+}
+
+type redisConfig struct {
+	Host string `env:"REDIS_HOST"`
+	Port int    `env:"REDIS_PORT" envDefault:"6379"`
+}
+
+func TestNilPointerStructPopulated(t *testing.T) {
+	type config struct {
+		Redis *redisConfig
+	}
+	os.Setenv("REDIS_HOST", "cache.local")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, &redisConfig{Host: "cache.local", Port: 6379}, cfg.Redis)
+}
+
+func TestNilPointerStructStaysNil(t *testing.T) {
+	type config struct {
+		Redis *redisConfig
+		Inner *InnerStruct
+	}
+	os.Setenv("UNRELATED", "value")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Nil(t, cfg.Redis)
+	assert.Nil(t, cfg.Inner)
+}
+
+func TestNilPointerStructInvalid(t *testing.T) {
+	type config struct {
+		Redis *redisConfig
+	}
+	os.Setenv("REDIS_PORT", "not-a-port")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.Error(t, Parse(&cfg))
+	assert.Nil(t, cfg.Redis)
+}
+
+func TestNilPointerRecursiveType(t *testing.T) {
+	type node struct {
+		Name string `env:"NODE_NAME"`
+		Next *node
+	}
+	os.Setenv("NODE_NAME", "head")
+	defer os.Clearenv()
+
+	cfg := node{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, "head", cfg.Name)
+}
+
+func TestNilPointerMutuallyRecursiveTypes(t *testing.T) {
+	os.Setenv("PARENT_NAME", "p")
+	os.Setenv("CHILD_NAME", "c")
+	defer os.Clearenv()
+
+	cfg := recursiveParent{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, "p", cfg.Name)
+	assert.Equal(t, "c", cfg.Child.Name)
+	assert.Nil(t, cfg.Child.Parent)
+}
+
+type recursiveParent struct {
+	Name  string `env:"PARENT_NAME"`
+	Child *recursiveChild
+}
+
+type recursiveChild struct {
+	Name   string `env:"CHILD_NAME"`
+	Parent *recursiveParent
+}
+
+func TestStrictRecursiveTypes(t *testing.T) {
+	cfg := recursiveParent{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+}
+
+func TestMissingRequiredGrouped(t *testing.T) {
+	type inner struct {
+		Baz string `env:"BAZ" required:"true"`
+	}
+	type config struct {
+		Foo   string `env:"FOO" required:"true"`
+		Port  int    `env:"PORT"`
+		Bar   string `env:"BAR" required:"true"`
+		Inner inner
+	}
+	os.Setenv("PORT", "not-a-port")
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Equal(t, `missing required env vars: FOO, BAR, BAZ. `+
+		`env var PORT: unable to parse "not-a-port" into field Port of type int: `+
+		`strconv.ParseInt: parsing "not-a-port": invalid syntax`, err.Error())
+
+	var parseErr *ParseError
+	assert.True(t, errors.As(err, &parseErr))
+	assert.Equal(t, "PORT", parseErr.Key)
+}
+
+func TestMissingRequiredSingle(t *testing.T) {
+	type config struct {
+		Foo string `env:"FOO" required:"true"`
+	}
+
+	cfg := config{}
+	err := ParseWithPrefix(&cfg, "APP_")
+	assert.Error(t, err)
+	assert.Equal(t, "missing required env var: APP_FOO", err.Error())
+}
+
+func TestDefaultsMap(t *testing.T) {
+	type config struct {
+		Host    string `env:"HOST" envDefault:"tag-host"`
+		Port    int    `env:"PORT"`
+		Name    string `env:"NAME" envDefault:"tag-name"`
+		Missing string `env:"MISSING"`
+	}
+	os.Setenv("APP_NAME", "env-name")
+	defer os.Clearenv()
+
+	defaults := map[string]string{
+		"APP_HOST": "map-host",
+		"APP_PORT": "9000",
+		"APP_NAME": "map-name",
+	}
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Prefix: "APP_", Defaults: defaults}))
+	assert.Equal(t, "tag-host", cfg.Host)
+	assert.Equal(t, 9000, cfg.Port)
+	assert.Equal(t, "env-name", cfg.Name)
+	assert.Equal(t, "", cfg.Missing)
+
+	cfg = config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Prefix: "APP_", Defaults: defaults, DefaultsOverrideTags: true}))
+	assert.Equal(t, "map-host", cfg.Host)
+	assert.Equal(t, 9000, cfg.Port)
+	assert.Equal(t, "env-name", cfg.Name)
+}
+
+func TestDefaultsMapDoesNotSatisfyRequired(t *testing.T) {
+	type config struct {
+		Host string `env:"HOST" required:"true"`
+	}
+
+	cfg := config{}
+	err := ParseWithOptions(&cfg, Options{Defaults: map[string]string{"HOST": "map-host"}})
+	assert.Error(t, err)
+}
+
+func TestMultiCharacterSeparators(t *testing.T) {
+	type config struct {
+		Pipes  []string  `env:"PIPES" envSeparator:"||"`
+		Spaced []string  `env:"SPACED" envSeparator:", "`
+		Ints   []int     `env:"INTS" envSeparator:"||"`
+		TLS    tlsConfig `env:"TLS" envInline:"true" envSeparator:"||"`
+	}
+	os.Setenv("PIPES", "a|b||c,d||e")
+	os.Setenv("SPACED", "a,b, c d, e")
+	os.Setenv("INTS", "1||2||3")
+	os.Setenv("TLS", "cert=/a,b||key=/c")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, []string{"a|b", "c,d", "e"}, cfg.Pipes)
+	assert.Equal(t, []string{"a,b", "c d", "e"}, cfg.Spaced)
+	assert.Equal(t, []int{1, 2, 3}, cfg.Ints)
+	assert.Equal(t, tlsConfig{Cert: "/a,b", Key: "/c"}, cfg.TLS)
+}
+
+func TestDecodeHexKey(t *testing.T) {
+	type config struct {
+		Key []byte `env:"KEY" envDecode:"hex"`
+	}
+	key := "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	os.Setenv("KEY", key)
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Len(t, cfg.Key, 32)
+	for i, b := range cfg.Key {
+		assert.Equal(t, byte(i), b)
+	}
+}
+
+func TestDecodeHexOddLength(t *testing.T) {
+	type config struct {
+		Key []byte `env:"KEY" envDecode:"hex"`
+	}
+	os.Setenv("KEY", "abc")
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Equal(t, `env var KEY: unable to parse "abc" into field Key of type []uint8: unable to decode hex value: encoding/hex: odd length hex string`, err.Error())
+}
+
+func TestDecodeBase64(t *testing.T) {
+	type config struct {
+		Key  []byte `env:"KEY" envDecode:"base64"`
+		Text string `env:"TEXT" envDecode:"base64"`
+	}
+	os.Setenv("KEY", "AAEC")
+	os.Setenv("TEXT", "aGVsbG8=")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, []byte{0, 1, 2}, cfg.Key)
+	assert.Equal(t, "hello", cfg.Text)
+}
+
+func TestSortedSlices(t *testing.T) {
+	type config struct {
+		IntsAsc     []int           `env:"INTS" envSort:"asc"`
+		IntsDesc    []int           `env:"INTS" envSort:"desc"`
+		StringsAsc  []string        `env:"STRINGS" envSort:"asc"`
+		StringsDesc []string        `env:"STRINGS" envSort:"desc"`
+		Int64s      []int64         `env:"INT64S" envSort:"asc"`
+		Float64s    []float64       `env:"FLOAT64S" envSort:"desc"`
+		Durations   []time.Duration `env:"DURATIONS" envSort:"asc"`
+	}
+	os.Setenv("INTS", "3,1,2")
+	os.Setenv("STRINGS", "pear,apple,fig")
+	os.Setenv("INT64S", "10,-5,0")
+	os.Setenv("FLOAT64S", "0.5,2.5,1.5")
+	os.Setenv("DURATIONS", "1m,1s,1h")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, []int{1, 2, 3}, cfg.IntsAsc)
+	assert.Equal(t, []int{3, 2, 1}, cfg.IntsDesc)
+	assert.Equal(t, []string{"apple", "fig", "pear"}, cfg.StringsAsc)
+	assert.Equal(t, []string{"pear", "fig", "apple"}, cfg.StringsDesc)
+	assert.Equal(t, []int64{-5, 0, 10}, cfg.Int64s)
+	assert.Equal(t, []float64{2.5, 1.5, 0.5}, cfg.Float64s)
+	assert.Equal(t, []time.Duration{time.Second, time.Minute, time.Hour}, cfg.Durations)
+}
+
+func TestSortedSliceErrors(t *testing.T) {
+	type config struct {
+		Ints []int     `env:"INTS" envSort:"random"`
+		URLs []url.URL `env:"URLS" envSort:"asc"`
+		Bad  []bool    `env:"BOOLS" envSort:"asc"`
+	}
+	os.Setenv("INTS", "3,1,2")
+	os.Setenv("URLS", "http://b,http://a")
+	os.Setenv("BOOLS", "true,false")
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid envSort tag "random"`)
+	assert.Contains(t, err.Error(), "envSort is not supported for []url.URL")
+	assert.Contains(t, err.Error(), "envSort is not supported for []bool")
+}
+
+func TestClockDuration(t *testing.T) {
+	type config struct {
+		Long  time.Duration `env:"LONG" envDuration:"clock"`
+		Short time.Duration `env:"SHORT" envDuration:"clock"`
+	}
+	os.Setenv("LONG", "01:30:00")
+	os.Setenv("SHORT", "90:00")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, 90*time.Minute, cfg.Long)
+	assert.Equal(t, 90*time.Minute, cfg.Short)
+}
+
+func TestClockDurationMalformed(t *testing.T) {
+	type config struct {
+		Timeout time.Duration `env:"TIMEOUT" envDuration:"clock"`
+	}
+	defer os.Clearenv()
+
+	for _, value := range []string{"90", "1:2:3:4", "01:60:00", "aa:00", "01::00", "-1:00", "2562048:00:00", "2562047:59:59"} {
+		os.Setenv("TIMEOUT", value)
+		cfg := config{}
+		err := Parse(&cfg)
+		if assert.Error(t, err, value) {
+			assert.Contains(t, err.Error(), "invalid clock duration", value)
+		}
+	}
+}
+
+func TestNormalizeKey(t *testing.T) {
+	type config struct {
+		Host string `env:"db_host"`
+		Port int    `env:"db_port" envDefault:"5432"`
+	}
+	os.Setenv("DB_HOST", "db.internal")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{NormalizeKey: strings.ToUpper}))
+	assert.Equal(t, "db.internal", cfg.Host)
+	assert.Equal(t, 5432, cfg.Port)
+
+	cfg = config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, "", cfg.Host)
+}
+
+func TestJSONNumber(t *testing.T) {
+	type config struct {
+		Big   json.Number `env:"BIG"`
+		Ratio json.Number `env:"RATIO"`
+	}
+	os.Setenv("BIG", "123456789012345678901234567890")
+	os.Setenv("RATIO", "0.125")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, json.Number("123456789012345678901234567890"), cfg.Big)
+	ratio, err := cfg.Ratio.Float64()
+	assert.NoError(t, err)
+	assert.Equal(t, 0.125, ratio)
+}
+
+func TestJSONNumberMalformed(t *testing.T) {
+	type config struct {
+		Limit json.Number `env:"LIMIT"`
+	}
+	os.Setenv("LIMIT", "12abc")
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := Parse(&cfg)
+	assert.EqualError(t, err, `env var LIMIT: unable to parse "12abc" into field Limit of type json.Number: strconv.ParseFloat: parsing "12abc": invalid syntax`)
+}
+
+type endpointConfig struct {
+	Endpoint string `env:"ENDPOINT" envParser:"ParseEndpoint"`
+	Ports    []int  `env:"PORTS" envParser:"ParsePorts"`
+	Missing  string `env:"MISSING" envParser:"NoSuchMethod"`
+	Wrong    string `env:"WRONG" envParser:"String"`
+	Scheme   string
+}
+
+func (c *endpointConfig) ParseEndpoint(value string) error {
+	if !strings.Contains(value, "://") {
+		return errors.New("missing scheme")
+	}
+	c.Endpoint = strings.TrimSuffix(value, "/")
+	return nil
+}
+
+func (c *endpointConfig) ParsePorts(value string) (interface{}, error) {
+	var ports []int
+	for _, part := range strings.Split(value, " ") {
+		port, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+func (c *endpointConfig) String() string {
+	return c.Endpoint
+}
+
+func TestMethodParser(t *testing.T) {
+	os.Setenv("ENDPOINT", "https://api.example.com/")
+	os.Setenv("PORTS", "80 443")
+	defer os.Clearenv()
+
+	cfg := endpointConfig{Scheme: "keep"}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, "https://api.example.com", cfg.Endpoint)
+	assert.Equal(t, []int{80, 443}, cfg.Ports)
+	assert.Equal(t, "keep", cfg.Scheme)
+}
+
+func TestMethodParserErrors(t *testing.T) {
+	os.Setenv("ENDPOINT", "api.example.com")
+	os.Setenv("MISSING", "x")
+	os.Setenv("WRONG", "x")
+	defer os.Clearenv()
+
+	cfg := endpointConfig{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `env var ENDPOINT: unable to parse "api.example.com" into field Endpoint of type string: missing scheme`)
+	assert.Contains(t, err.Error(), "envParser method NoSuchMethod not found on *env.endpointConfig")
+	assert.Contains(t, err.Error(), "envParser method String must be func(string) error or func(string) (interface{}, error)")
+}
+
+func TestDecodeByteArray(t *testing.T) {
+	type config struct {
+		AESKey [16]byte `env:"AES_KEY" envDecode:"hex"`
+		Nonce  [4]byte  `env:"NONCE" envDecode:"base64"`
+	}
+	os.Setenv("AES_KEY", "000102030405060708090a0b0c0d0e0f")
+	os.Setenv("NONCE", "3q2+7w==")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, [16]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, cfg.AESKey)
+	assert.Equal(t, [4]byte{0xde, 0xad, 0xbe, 0xef}, cfg.Nonce)
+}
+
+func TestDecodeByteArrayLengthMismatch(t *testing.T) {
+	type config struct {
+		AESKey [16]byte `env:"AES_KEY" envDecode:"hex"`
+	}
+	os.Setenv("AES_KEY", "00010203")
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := Parse(&cfg)
+	assert.EqualError(t, err, `env var AES_KEY: unable to parse "00010203" into field AESKey of type [16]uint8: decoded hex value is 4 bytes, expected 16`)
+	assert.Equal(t, [16]byte{}, cfg.AESKey)
+}
+
+func TestMaps(t *testing.T) {
+	type config struct {
+		Labels  map[string]string        `env:"LABELS"`
+		Weights map[string]int           `env:"WEIGHTS"`
+		Routes  map[string][]string      `env:"ROUTES"`
+		Custom  map[string][]int         `env:"CUSTOM" envMapSeparator:";" envKeyValSeparator:"=" envSeparator:"|"`
+		Waits   map[string]time.Duration `env:"WAITS"`
+	}
+	os.Setenv("LABELS", "team:core,tier:gold")
+	os.Setenv("WEIGHTS", "a:1,b:-2")
+	os.Setenv("ROUTES", "web:/a;/b,api:/v1")
+	os.Setenv("CUSTOM", "x=1|2|3;y=4")
+	os.Setenv("WAITS", "read:1s,write:2m")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, map[string]string{"team": "core", "tier": "gold"}, cfg.Labels)
+	assert.Equal(t, map[string]int{"a": 1, "b": -2}, cfg.Weights)
+	assert.Equal(t, map[string][]string{"web": {"/a", "/b"}, "api": {"/v1"}}, cfg.Routes)
+	assert.Equal(t, map[string][]int{"x": {1, 2, 3}, "y": {4}}, cfg.Custom)
+	assert.Equal(t, map[string]time.Duration{"read": time.Second, "write": 2 * time.Minute}, cfg.Waits)
+}
+
+func TestMapErrors(t *testing.T) {
+	type config struct {
+		Entry   map[string]string   `env:"ENTRY"`
+		Value   map[string]int      `env:"VALUE"`
+		Collide map[string][]string `env:"COLLIDE" envSeparator:","`
+		Nested  map[string]string   `env:"NESTED" envMapSeparator:"::" envKeyValSeparator:":"`
+	}
+	os.Setenv("ENTRY", "a:1,b")
+	os.Setenv("VALUE", "a:x")
+	os.Setenv("COLLIDE", "a:1,2")
+	os.Setenv("NESTED", "a:1::b:2")
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `env var ENTRY: unable to parse "b" into field Entry of type map[string]string: invalid map entry: expected key:value`)
+	assert.Contains(t, err.Error(), `unable to parse "x" into field Value of type int`)
+	assert.Contains(t, err.Error(), `map separators ["," ":" ","] for Collide collide`)
+	assert.Contains(t, err.Error(), `map separators ["::" ":"] for Nested collide`)
+}
+
+func TestMapValueTags(t *testing.T) {
+	type config struct {
+		Limits map[string]int      `env:"LIMITS"`
+		Names  map[string]string   `env:"NAMES"`
+		Tags   map[string][]string `env:"TAGS" envElemMaxLen:"3" envSort:"asc"`
+	}
+	os.Setenv("LIMITS", "a:1,b:")
+	os.Setenv("NAMES", "a:")
+	os.Setenv("TAGS", "db:zeta;alpha")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Equal(t, map[string]int{"a": 1, "b": 0}, cfg.Limits)
+	assert.Equal(t, map[string]string{"a": ""}, cfg.Names)
+	assert.Equal(t, map[string][]string{"db": {"alpha", "zeta"}}, cfg.Tags)
+}
+
+func TestIntegerKeyedMaps(t *testing.T) {
+	type config struct {
+		Priorities map[int]string   `env:"PRIORITIES"`
+		Limits     map[uint64]int   `env:"LIMITS"`
+		Offsets    map[int64]string `env:"OFFSETS"`
+	}
+	os.Setenv("PRIORITIES", "1:low,2:high")
+	os.Setenv("LIMITS", "10:1,20:2")
+	os.Setenv("OFFSETS", "-1:before")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, map[int]string{1: "low", 2: "high"}, cfg.Priorities)
+	assert.Equal(t, map[uint64]int{10: 1, 20: 2}, cfg.Limits)
+	assert.Equal(t, map[int64]string{-1: "before"}, cfg.Offsets)
+
+	os.Setenv("PRIORITIES", "1:low,urgent:high")
+	cfg = config{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `unable to parse "urgent" into field Priorities of type map[int]string: invalid map key`)
+}
+
+type logLevel int
+
+func (l *logLevel) String() string {
+	return strconv.Itoa(int(*l))
+}
+
+func (l *logLevel) Set(value string) error {
+	switch value {
+	case "debug":
+		*l = 0
+	case "info":
+		*l = 1
+	case "error":
+		*l = 2
+	default:
+		return fmt.Errorf("unknown level %q", value)
+	}
+	return nil
+}
+
+func TestFlagValue(t *testing.T) {
+	type config struct {
+		Level logLevel `env:"LEVEL"`
+	}
+	os.Setenv("LEVEL", "error")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Equal(t, logLevel(2), cfg.Level)
+
+	os.Setenv("LEVEL", "loud")
+	err := Parse(&cfg)
+	assert.EqualError(t, err, `env var LEVEL: unable to parse "loud" into field Level of type env.logLevel: unknown level "loud"`)
+}
+
+// sizeValue has the Set and Type methods of a pflag value but no String
+// method, so it is not a flag.Value.
+type sizeValue struct {
+	n int
+}
+
+func (s *sizeValue) Set(value string) error {
+	switch strings.ToLower(value) {
+	case "small":
+		s.n = 1
+	case "large":
+		s.n = 3
+	default:
+		return fmt.Errorf("unknown size %q", value)
+	}
+	return nil
+}
+
+func (s *sizeValue) Type() string {
+	return "size"
+}
+
+func TestTypedValue(t *testing.T) {
+	type config struct {
+		Size sizeValue `env:"SIZE"`
+	}
+	os.Setenv("SIZE", "Large")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Equal(t, 3, cfg.Size.n)
+
+	os.Setenv("SIZE", "huge")
+	err := Parse(&cfg)
+	assert.EqualError(t, err, `env var SIZE: unable to parse "huge" into field Size of type env.sizeValue: unknown size "huge"`)
+}
+
+var countingUnmarshalCalls int
+
+type countingUnmarshaler struct {
+	Value string
+}
+
+func (c *countingUnmarshaler) UnmarshalText(data []byte) error {
+	countingUnmarshalCalls++
+	if len(data) == 0 {
+		return errors.New("empty value")
+	}
+	c.Value = strings.ToUpper(string(data))
+	return nil
+}
+
+func TestTextUnmarshalerSlices(t *testing.T) {
+	type config struct {
+		Values   []countingUnmarshaler  `env:"VALUES"`
+		Pointers []*countingUnmarshaler `env:"POINTERS"`
+	}
+	os.Setenv("VALUES", "a,b,c")
+	os.Setenv("POINTERS", "x,y")
+	defer os.Clearenv()
+	countingUnmarshalCalls = 0
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, 5, countingUnmarshalCalls)
+	assert.Equal(t, []countingUnmarshaler{{"A"}, {"B"}, {"C"}}, cfg.Values)
+	assert.Equal(t, []*countingUnmarshaler{{"X"}, {"Y"}}, cfg.Pointers)
+	assert.True(t, cfg.Pointers[0] != cfg.Pointers[1])
+}
+
+func TestTextUnmarshalerSliceError(t *testing.T) {
+	type config struct {
+		Pointers []*countingUnmarshaler `env:"POINTERS"`
+	}
+	os.Setenv("POINTERS", "x,,y")
+	defer os.Clearenv()
+	countingUnmarshalCalls = 0
+
+	cfg := config{}
+	assert.EqualError(t, Parse(&cfg), "empty value")
+	assert.Equal(t, 2, countingUnmarshalCalls)
+	assert.Nil(t, cfg.Pointers)
+}
+
+func TestCustomBoolWords(t *testing.T) {
+	type config struct {
+		Feature bool   `env:"FEATURE" envTrue:"enabled" envFalse:"disabled"`
+		Other   bool   `env:"OTHER" envTrue:"enabled" envFalse:"disabled"`
+		Plain   bool   `env:"PLAIN" envTrue:"yes"`
+		Modes   []bool `env:"MODES" envTrue:"on" envFalse:"off"`
+	}
+	os.Setenv("FEATURE", "Enabled")
+	os.Setenv("OTHER", "DISABLED")
+	os.Setenv("PLAIN", "true")
+	os.Setenv("MODES", "on,off,ON")
+	defer os.Clearenv()
+
+	cfg := config{Other: true}
+	assert.NoError(t, Parse(&cfg))
+	assert.True(t, cfg.Feature)
+	assert.False(t, cfg.Other)
+	assert.True(t, cfg.Plain)
+	assert.Equal(t, []bool{true, false, true}, cfg.Modes)
+}
+
+func TestCustomBoolWordsUnrecognized(t *testing.T) {
+	type config struct {
+		Feature bool `env:"FEATURE" envTrue:"enabled" envFalse:"disabled" envBool:"numeric"`
+	}
+	os.Setenv("FEATURE", "maybe")
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := Parse(&cfg)
+	assert.EqualError(t, err, `env var FEATURE: unable to parse "maybe" into field Feature of type bool: invalid numeric bool "maybe": expected 0 or 1`)
+}
+
+type tagList []string
+
+type idList []int
+
+func TestNamedSliceTypes(t *testing.T) {
+	type config struct {
+		Tags      tagList         `env:"TAGS"`
+		IDs       idList          `env:"IDS" envSort:"asc"`
+		Durations []time.Duration `env:"DURATIONS"`
+	}
+	os.Setenv("TAGS", "a,b")
+	os.Setenv("IDS", "3,1,2")
+	os.Setenv("DURATIONS", "1s")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Equal(t, tagList{"a", "b"}, cfg.Tags)
+	assert.Equal(t, idList{1, 2, 3}, cfg.IDs)
+	assert.Equal(t, []time.Duration{time.Second}, cfg.Durations)
+
+	os.Setenv("IDS", "1,x")
+	assert.Error(t, Parse(&cfg))
+}
+
+func TestUnquote(t *testing.T) {
+	type config struct {
+		Port    int      `env:"PORT" envUnquote:"true"`
+		List    string   `env:"LIST" envUnquote:"true"`
+		Hosts   []string `env:"HOSTS" envUnquote:"true"`
+		Partial string   `env:"PARTIAL" envUnquote:"true"`
+		Raw     string   `env:"RAW"`
+	}
+	os.Setenv("PORT", `"8080"`)
+	os.Setenv("LIST", `'a,b'`)
+	os.Setenv("HOSTS", `"a,b"`)
+	os.Setenv("PARTIAL", `"abc'`)
+	os.Setenv("RAW", `"quoted"`)
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, 8080, cfg.Port)
+	assert.Equal(t, "a,b", cfg.List)
+	assert.Equal(t, []string{"a", "b"}, cfg.Hosts)
+	assert.Equal(t, `"abc'`, cfg.Partial)
+	assert.Equal(t, `"quoted"`, cfg.Raw)
+}
+
+func TestRequiredIf(t *testing.T) {
+	type config struct {
+		Cert    string `env:"TLS_CERT" envRequiredIf:"TLS_ENABLED=true"`
+		Key     string `env:"TLS_KEY" envRequiredIf:"TLS_ENABLED=true"`
+		Enabled bool   `env:"TLS_ENABLED"`
+	}
+	defer os.Clearenv()
+
+	// the condition holds, so the unset fields are missing
+	os.Setenv("TLS_ENABLED", "TRUE")
+	os.Setenv("TLS_KEY", "/etc/tls.key")
+	cfg := config{}
+	assert.EqualError(t, Parse(&cfg), "missing required env var: TLS_CERT")
+	assert.Equal(t, []string{"TLS_CERT"}, Missing(&cfg, Options{}))
+
+	// the condition does not hold
+	os.Setenv("TLS_ENABLED", "false")
+	os.Unsetenv("TLS_KEY")
+	cfg = config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Empty(t, Missing(&cfg, Options{}))
+}
+
+func TestRequiredIfBool(t *testing.T) {
+	type config struct {
+		Cert    string `env:"TLS_CERT" envRequiredIf:"TLS_ENABLED=true"`
+		Plain   string `env:"PLAIN" envRequiredIf:"TLS_ENABLED=false"`
+		Enabled bool   `env:"TLS_ENABLED"`
+	}
+	defer os.Clearenv()
+
+	for _, value := range []string{"1", "t", "TRUE", "True"} {
+		os.Setenv("TLS_ENABLED", value)
+		assert.EqualError(t, Parse(&config{}), "missing required env var: TLS_CERT", value)
+	}
+	os.Setenv("TLS_ENABLED", "0")
+	assert.EqualError(t, Parse(&config{}), "missing required env var: PLAIN")
+
+	type invalidConfig struct {
+		Cert    string `env:"TLS_CERT" envRequiredIf:"TLS_ENABLED=yes"`
+		Enabled bool   `env:"TLS_ENABLED"`
+	}
+	assert.EqualError(t, Parse(&invalidConfig{}), `invalid envRequiredIf tag "TLS_ENABLED=yes": TLS_ENABLED is a bool`)
+}
+
+func TestRequiredIfUsesDefault(t *testing.T) {
+	type config struct {
+		Cert    string `env:"CERT" envRequiredIf:"TLS=on"`
+		TLS     string `env:"TLS" envDefault:"on"`
+		Invalid string `env:"INVALID" envRequiredIf:"TLS"`
+	}
+	os.Clearenv()
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := ParseWithPrefix(&cfg, "APP_")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid envRequiredIf tag "TLS": expected KEY=VALUE`)
+	assert.Contains(t, err.Error(), "missing required env var: APP_CERT")
+}
+
+func TestOrderedPairs(t *testing.T) {
+	type header struct {
+		Key, Value string
+	}
+	type config struct {
+		Headers []header `env:"HEADERS"`
+		Colons  []struct {
+			Key   string
+			Value string
+		} `env:"COLONS" envSeparator:";" envKeyValSeparator:":"`
+	}
+	os.Setenv("HEADERS", "z=last,a=first,m=a=b")
+	os.Setenv("COLONS", "b:2;a:1")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Equal(t, []header{{"z", "last"}, {"a", "first"}, {"m", "a=b"}}, cfg.Headers)
+	assert.Len(t, cfg.Colons, 2)
+	assert.Equal(t, "b", cfg.Colons[0].Key)
+	assert.Equal(t, "1", cfg.Colons[1].Value)
+
+	os.Setenv("HEADERS", "a=1,b")
+	assert.EqualError(t, Parse(&cfg), `env var HEADERS: unable to parse "b" into field Headers of type []env.header: invalid pair: expected key=value`)
+}
+
+func TestPrefixFromEnv(t *testing.T) {
+	type config struct {
+		Host string `env:"HOST" required:"true"`
+	}
+	os.Setenv("TENANT", "ACME")
+	os.Setenv("ACME_HOST", "acme.example.com")
+	os.Setenv("OTHER_HOST", "other.example.com")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithPrefix(&cfg, "${TENANT}_"))
+	assert.Equal(t, "acme.example.com", cfg.Host)
+
+	os.Setenv("TENANT", "OTHER")
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Prefix: "${TENANT}_"}))
+	assert.Equal(t, "other.example.com", cfg.Host)
+
+	os.Setenv("TENANT", "NONE")
+	assert.Equal(t, []string{"NONE_HOST"}, Missing(&cfg, Options{Prefix: "${TENANT}_"}))
+}
+
+func TestBoolPointer(t *testing.T) {
+	type config struct {
+		On      *bool `env:"ON"`
+		Off     *bool `env:"OFF"`
+		Unset   *bool `env:"UNSET"`
+		Numeric *bool `env:"NUMERIC" envBool:"numeric"`
+	}
+	os.Setenv("ON", "true")
+	os.Setenv("OFF", "false")
+	os.Setenv("NUMERIC", "1")
+	defer os.Clearenv()
+
+	off := true
+	cfg := config{Off: &off}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	if assert.NotNil(t, cfg.On) {
+		assert.True(t, *cfg.On)
+	}
+	if assert.NotNil(t, cfg.Off) {
+		assert.False(t, *cfg.Off)
+	}
+	assert.Nil(t, cfg.Unset)
+	if assert.NotNil(t, cfg.Numeric) {
+		assert.True(t, *cfg.Numeric)
+	}
+
+	os.Setenv("ON", "maybe")
+	assert.Error(t, Parse(&cfg))
+}
+
+func TestKeyPattern(t *testing.T) {
+	type database struct {
+		DBHost string
+	}
+	type config struct {
+		MaxConns int
+		HTTPPort int `envDefault:"8080"`
+		Name     string
+		Debug    *bool
+		Override string `env:"CUSTOM"`
+		DB       database
+		Timeouts []time.Duration
+		private  string
+	}
+	os.Setenv("APP_MAX_CONNS", "10")
+	os.Setenv("APP_NAME", "svc")
+	os.Setenv("APP_DEBUG", "true")
+	os.Setenv("CUSTOM", "tagged")
+	os.Setenv("APP_DB_HOST", "db")
+	os.Setenv("APP_TIMEOUTS", "1s,2s")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{KeyPattern: "APP_{FIELD}", Strict: true}))
+	assert.Equal(t, 10, cfg.MaxConns)
+	assert.Equal(t, 8080, cfg.HTTPPort)
+	assert.Equal(t, "svc", cfg.Name)
+	assert.True(t, *cfg.Debug)
+	assert.Equal(t, "tagged", cfg.Override)
+	assert.Equal(t, "db", cfg.DB.DBHost)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, cfg.Timeouts)
+	assert.Equal(t, "", cfg.private)
+
+	cfg = config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, 0, cfg.MaxConns)
+}
+
+func TestScreamingSnake(t *testing.T) {
+	for name, expected := range map[string]string{
+		"Name":     "NAME",
+		"MaxConns": "MAX_CONNS",
+		"HTTPPort": "HTTP_PORT",
+		"DBHost":   "DB_HOST",
+		"ID":       "ID",
+	} {
+		assert.Equal(t, expected, screamingSnake(name), name)
+	}
+}
+
+func TestElemMaxLen(t *testing.T) {
+	type config struct {
+		Names []string `env:"NAMES" envElemMaxLen:"5"`
+		Ports []int    `env:"PORTS" envElemMaxLen:"4"`
+	}
+	os.Setenv("NAMES", "alpha,beta,gamma")
+	os.Setenv("PORTS", "80,8080")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, []string{"alpha", "beta", "gamma"}, cfg.Names)
+	assert.Equal(t, []int{80, 8080}, cfg.Ports)
+
+	os.Setenv("NAMES", "alpha,epsilon")
+	cfg = config{}
+	assert.EqualError(t, Parse(&cfg), "element 1 of Names is 7 bytes long, exceeding envElemMaxLen 5")
+	assert.Nil(t, cfg.Names)
+}
+
+func TestElemMaxLenInvalidTag(t *testing.T) {
+	type config struct {
+		Names []string `env:"NAMES" envElemMaxLen:"many"`
+	}
+	os.Setenv("NAMES", "a")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.EqualError(t, Parse(&cfg), `invalid envElemMaxLen tag "many"`)
+}
+
+func TestJSONMapOfStructs(t *testing.T) {
+	type config struct {
+		Servers map[string]ServerConfig `env:"SERVERS" envJSON:"true"`
+	}
+	os.Setenv("SERVERS", `{"web": {"Host": "web.internal", "Port": 8080}, "api": {"host": "api.internal"}}`)
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Equal(t, map[string]ServerConfig{
+		"web": {Host: "web.internal", Port: 8080},
+		"api": {Host: "api.internal"},
+	}, cfg.Servers)
+}
+
+func TestJSONSliceOfMaps(t *testing.T) {
+	type config struct {
+		Rules []map[string]string `env:"RULES" envJSON:"true"`
+		Unset []map[string]string `env:"UNSET" envJSON:"true"`
+	}
+	os.Setenv("RULES", `[{"match": "/api", "backend": "api"}, {"match": "/"}]`)
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Equal(t, []map[string]string{
+		{"match": "/api", "backend": "api"},
+		{"match": "/"},
+	}, cfg.Rules)
+	assert.Nil(t, cfg.Unset)
+}
+
+func TestJSONMalformed(t *testing.T) {
+	type config struct {
+		Servers map[string]ServerConfig `env:"SERVERS" envJSON:"true"`
+	}
+	os.Setenv("SERVERS", `{"web": {"Port": "high"}}`)
+	defer os.Clearenv()
+
+	cfg := config{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "env var SERVERS: unable to parse")
+	assert.Nil(t, cfg.Servers)
+}
+
+func TestDurationPointer(t *testing.T) {
+	type config struct {
+		Retention *time.Duration `env:"RETENTION" envDuration:"extended"`
+		Timeout   *time.Duration `env:"TIMEOUT" envDuration:"extended"`
+		Plain     *time.Duration `env:"PLAIN"`
+		Unset     *time.Duration `env:"UNSET" envDuration:"extended"`
+	}
+	os.Setenv("RETENTION", "2d")
+	os.Setenv("TIMEOUT", "30s")
+	os.Setenv("PLAIN", "1m")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	if assert.NotNil(t, cfg.Retention) {
+		assert.Equal(t, 48*time.Hour, *cfg.Retention)
+	}
+	if assert.NotNil(t, cfg.Timeout) {
+		assert.Equal(t, 30*time.Second, *cfg.Timeout)
+	}
+	if assert.NotNil(t, cfg.Plain) {
+		assert.Equal(t, time.Minute, *cfg.Plain)
+	}
+	assert.Nil(t, cfg.Unset)
+}
+
+func TestExtendedDuration(t *testing.T) {
+	type config struct {
+		Window time.Duration `env:"WINDOW" envDuration:"extended"`
+	}
+	defer os.Clearenv()
+
+	for value, expected := range map[string]time.Duration{
+		"1w":      7 * 24 * time.Hour,
+		"1w3d12h": 10*24*time.Hour + 12*time.Hour,
+		"1.5d":    36 * time.Hour,
+		"90m":     90 * time.Minute,
+	} {
+		os.Setenv("WINDOW", value)
+		cfg := config{}
+		assert.NoError(t, Parse(&cfg), value)
+		assert.Equal(t, expected, cfg.Window, value)
+	}
+
+	os.Setenv("WINDOW", "2days")
+	assert.EqualError(t, Parse(&config{}), `env var WINDOW: unable to parse "2days" into field Window of type time.Duration: invalid duration "2days"`)
+}
+
+func TestGrouping(t *testing.T) {
+	type config struct {
+		Budget  int     `env:"BUDGET" envGrouping:"true"`
+		Small   int64   `env:"SMALL" envGrouping:"true"`
+		Ratio   float64 `env:"RATIO" envGrouping:"true"`
+		Numbers []int   `env:"NUMBERS" envGrouping:"true"`
+		Label   string  `env:"LABEL" envGrouping:"true"`
+	}
+	os.Setenv("BUDGET", "1,000,000")
+	os.Setenv("SMALL", "-999")
+	os.Setenv("RATIO", "12,345.5")
+	os.Setenv("NUMBERS", "1,000,000")
+	os.Setenv("LABEL", "1,000")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, 1000000, cfg.Budget)
+	assert.Equal(t, int64(-999), cfg.Small)
+	assert.Equal(t, 12345.5, cfg.Ratio)
+	assert.Equal(t, []int{1, 0, 0}, cfg.Numbers)
+	assert.Equal(t, "1,000", cfg.Label)
+}
+
+func TestGroupingInvalid(t *testing.T) {
+	type config struct {
+		Budget int `env:"BUDGET" envGrouping:"true"`
+	}
+	defer os.Clearenv()
+
+	for _, value := range []string{"1,00,000", "1000,000", ",100", "1,"} {
+		os.Setenv("BUDGET", value)
+		assert.EqualError(t, Parse(&config{}), fmt.Sprintf(
+			"env var BUDGET: unable to parse %q into field Budget of type int: invalid digit grouping in %q", value, value))
+	}
+}
+
+func TestGroupingOptional(t *testing.T) {
+	type config struct {
+		Budget int `env:"BUDGET" envGrouping:"true"`
+	}
+	defer os.Clearenv()
+
+	for value, want := range map[string]int{"5000": 5000, "1000000": 1000000, "-1234": -1234, "1,000": 1000} {
+		os.Setenv("BUDGET", value)
+		cfg := config{}
+		assert.NoError(t, Parse(&cfg))
+		assert.Equal(t, want, cfg.Budget)
+	}
+}
+
+type percentage int
+
+func (p percentage) Validate() error {
+	if p < 0 || p > 100 {
+		return fmt.Errorf("%d is out of range 0-100", int(p))
+	}
+	return nil
+}
+
+type portNumber struct {
+	n int
+}
+
+func (p *portNumber) UnmarshalText(text []byte) (err error) {
+	p.n, err = strconv.Atoi(string(text))
+	return err
+}
+
+func (p *portNumber) Validate() error {
+	if p.n < 1 || p.n > 65535 {
+		return errors.New("port must be between 1 and 65535")
+	}
+	return nil
+}
+
+func TestFieldValidate(t *testing.T) {
+	type config struct {
+		Load  percentage  `env:"LOAD"`
+		Port  portNumber  `env:"PORT"`
+		Admin *portNumber `env:"ADMIN"`
+		Idle  percentage  `env:"IDLE"`
+	}
+	os.Setenv("LOAD", "75")
+	os.Setenv("PORT", "8080")
+	os.Setenv("ADMIN", "9090")
+	defer os.Clearenv()
+
+	cfg := config{Idle: 200}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, percentage(75), cfg.Load)
+	assert.Equal(t, 8080, cfg.Port.n)
+	assert.Equal(t, 9090, cfg.Admin.n)
+
+	os.Setenv("LOAD", "150")
+	os.Setenv("ADMIN", "0")
+	cfg = config{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "env var LOAD: invalid value for field Load: 150 is out of range 0-100")
+	assert.Contains(t, err.Error(), "env var ADMIN: invalid value for field Admin: port must be between 1 and 65535")
+}
+
+func TestOnValidationError(t *testing.T) {
+	type config struct {
+		Load percentage `env:"LOAD"`
+		Idle percentage `env:"IDLE"`
+	}
+	os.Setenv("LOAD", "150")
+	os.Setenv("IDLE", "abc")
+	defer os.Clearenv()
+
+	var reported []string
+	opts := Options{OnValidationError: func(field reflect.StructField, value string, err error) {
+		reported = append(reported, field.Name+"="+value+": "+err.Error())
+	}}
+	cfg := config{}
+	err := ParseWithOptions(&cfg, opts)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `unable to parse "abc" into field Idle`)
+	assert.Equal(t, []string{"Load=150: env var LOAD: invalid value for field Load: 150 is out of range 0-100"}, reported)
+}
+
+type windowConfig struct {
+	Start int `env:"START"`
+	End   int `env:"END"`
+}
+
+func (c *windowConfig) Validate() error {
+	if c.Start >= c.End {
+		return fmt.Errorf("START (%d) must be before END (%d)", c.Start, c.End)
+	}
+	return nil
+}
+
+func TestStructValidate(t *testing.T) {
+	os.Setenv("START", "1")
+	os.Setenv("END", "5")
+	defer os.Clearenv()
+
+	cfg := windowConfig{}
+	assert.NoError(t, Parse(&cfg))
+
+	os.Setenv("END", "1")
+	assert.EqualError(t, Parse(&cfg), "START (1) must be before END (1)")
+
+	// Validate is not called when a field fails to parse
+	os.Setenv("END", "soon")
+	err := Parse(&windowConfig{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "env var END: unable to parse")
+	assert.NotContains(t, err.Error(), "must be before")
+
+	// nor by Plan, which leaves the struct unchanged
+	os.Setenv("END", "1")
+	plan, err := Plan(&windowConfig{}, Options{})
+	assert.NoError(t, err)
+	assert.Len(t, plan, 2)
+}
+
+type environment string
+
+func (environment) Values() []string {
+	return []string{"dev", "staging", "prod"}
+}
+
+func TestValuesEnum(t *testing.T) {
+	type config struct {
+		Env environment `env:"ENVIRONMENT"`
+	}
+	os.Setenv("ENVIRONMENT", "staging")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, environment("staging"), cfg.Env)
+
+	os.Setenv("ENVIRONMENT", "qa")
+	assert.EqualError(t, Parse(&cfg), `env var ENVIRONMENT: unable to parse "qa" into field Env of type env.environment: expected one of dev, staging, prod`)
+	assert.Equal(t, environment("staging"), cfg.Env)
+}
+
+func TestPresence(t *testing.T) {
+	type config struct {
+		Empty bool `env:"EMPTY" envPresence:"true"`
+		Value bool `env:"VALUE" envPresence:"true"`
+		False bool `env:"FALSE" envPresence:"true"`
+		Unset bool `env:"UNSET" envPresence:"true"`
+	}
+	os.Setenv("EMPTY", "")
+	os.Setenv("VALUE", "anything")
+	os.Setenv("FALSE", "false")
+	defer os.Clearenv()
+
+	cfg := config{Unset: true}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.True(t, cfg.Empty)
+	assert.True(t, cfg.Value)
+	assert.True(t, cfg.False)
+	assert.False(t, cfg.Unset)
+}
+
+func TestPresenceUnsupportedType(t *testing.T) {
+	type config struct {
+		Name string `env:"NAME" envPresence:"true"`
+	}
+	err := ParseWithOptions(&config{}, Options{Strict: true})
+	assert.EqualError(t, err, "field Name: Type is not supported")
+}
+
+func TestJSONNestedMaps(t *testing.T) {
+	type config struct {
+		Labels  map[string]map[string]string  `env:"LABELS" envJSON:"true"`
+		Limits  map[string]map[string][]int   `env:"LIMITS" envJSON:"true"`
+		Unset   map[string]map[string]string  `env:"UNSET" envJSON:"true"`
+		Weights map[string]map[string]float64 `env:"WEIGHTS" envJSON:"true"`
+	}
+	os.Setenv("LABELS", `{"web": {"team": "core", "tier": "gold"}, "db": {}}`)
+	os.Setenv("LIMITS", `{"api": {"rps": [10, 20]}}`)
+	os.Setenv("WEIGHTS", `{"a": {"b": 0.5}}`)
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Equal(t, "core", cfg.Labels["web"]["team"])
+	assert.Equal(t, "gold", cfg.Labels["web"]["tier"])
+	assert.Empty(t, cfg.Labels["db"])
+	assert.Equal(t, []int{10, 20}, cfg.Limits["api"]["rps"])
+	assert.Equal(t, 0.5, cfg.Weights["a"]["b"])
+	assert.Nil(t, cfg.Unset)
+
+	os.Setenv("LABELS", `{"web": {"team": 1}}`)
+	assert.Error(t, Parse(&config{}))
+}
+
+func TestApplyOverrides(t *testing.T) {
+	type database struct {
+		Host string `env:"DB_HOST"`
+	}
+	type config struct {
+		Port    int           `env:"PORT" envDefault:"80"`
+		Timeout time.Duration `env:"TIMEOUT" envDefault:"5s"`
+		Name    string        `env:"NAME" required:"true"`
+		DB      database
+	}
+	os.Setenv("APP_NAME", "svc")
+	os.Setenv("APP_DB_HOST", "db")
+	defer os.Clearenv()
+
+	cfg := config{}
+	opts := Options{Prefix: "APP_"}
+	assert.NoError(t, ParseWithOptions(&cfg, opts))
+
+	os.Setenv("APP_NAME", "ignored")
+	err := ApplyOverrides(&cfg, map[string]string{"APP_PORT": "8080", "APP_DB_HOST": "replica"}, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, config{Port: 8080, Timeout: 5 * time.Second, Name: "svc", DB: database{Host: "replica"}}, cfg)
+
+	err = ApplyOverrides(&cfg, map[string]string{"APP_PORT": "x"}, opts)
+	assert.Error(t, err)
+	assert.Equal(t, 8080, cfg.Port)
+
+	err = ApplyOverrides(&cfg, map[string]string{"PORT": "1", "APP_NOPE": "2"}, opts)
+	assert.EqualError(t, err, "unknown override keys: APP_NOPE, PORT")
+}
+
+func TestRunes(t *testing.T) {
+	type config struct {
+		Symbols []rune  `env:"SYMBOLS" envRunes:"true"`
+		Numbers []int32 `env:"NUMBERS"`
+	}
+	os.Setenv("SYMBOLS", "a,é€😀")
+	os.Setenv("NUMBERS", "1,2")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Equal(t, []rune{'a', ',', 'é', '€', '😀'}, cfg.Symbols)
+	assert.Equal(t, []int32{1, 2}, cfg.Numbers)
+
+	type wrong struct {
+		Name string `env:"SYMBOLS" envRunes:"true"`
+	}
+	assert.EqualError(t, Parse(&wrong{}), "Type is not supported")
+}
+
+func TestUintptr(t *testing.T) {
+	type config struct {
+		Decimal uintptr `env:"DECIMAL"`
+		Hex     uintptr `env:"HEX"`
+	}
+	os.Setenv("DECIMAL", "4096")
+	os.Setenv("HEX", "0x1f")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Equal(t, uintptr(4096), cfg.Decimal)
+	assert.Equal(t, uintptr(31), cfg.Hex)
+
+	os.Setenv("HEX", "0xzz")
+	assert.EqualError(t, Parse(&cfg), `env var HEX: unable to parse "0xzz" into field Hex of type uintptr: strconv.ParseUint: parsing "0xzz": invalid syntax`)
+}
+
+func TestCustomParserInterfaceType(t *testing.T) {
+	type config struct {
+		Listen net.Addr `env:"LISTEN"`
+		Unset  net.Addr `env:"UNSET"`
+	}
+	parseAddr := func(value string) (interface{}, error) {
+		u, err := url.Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "tcp" {
+			return nil, fmt.Errorf("unsupported network %q", u.Scheme)
+		}
+		return net.ResolveTCPAddr(u.Scheme, u.Host)
+	}
+	opts := Options{Strict: true, Parsers: CustomParsers{reflect.TypeOf((*net.Addr)(nil)).Elem(): parseAddr}}
+	os.Setenv("LISTEN", "tcp://127.0.0.1:8080")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, opts))
+	if assert.IsType(t, &net.TCPAddr{}, cfg.Listen) {
+		assert.Equal(t, "127.0.0.1:8080", cfg.Listen.String())
+		assert.Equal(t, 8080, cfg.Listen.(*net.TCPAddr).Port)
+	}
+	assert.Nil(t, cfg.Unset)
+
+	os.Setenv("LISTEN", "udp://127.0.0.1:53")
+	assert.EqualError(t, ParseWithOptions(&cfg, opts), `Custom parser error: unsupported network "udp"`)
+}
+
+func TestCustomParserWrongType(t *testing.T) {
+	type config struct {
+		Listen net.Addr `env:"LISTEN"`
+	}
+	os.Setenv("LISTEN", "x")
+	defer os.Clearenv()
+
+	parsers := CustomParsers{reflect.TypeOf((*net.Addr)(nil)).Elem(): func(v string) (interface{}, error) { return v, nil }}
+	err := ParseWithOptions(&config{}, Options{Parsers: parsers})
+	assert.EqualError(t, err, "Custom parser error: string is not assignable to net.Addr")
+}
+
+func TestTemplatedDefault(t *testing.T) {
+	type config struct {
+		BaseURL string `env:"BASE_URL" envDefault:"http://${HOST}:${PORT}" envTemplate:"true"`
+		Host    string `env:"HOST" envDefault:"localhost"`
+		Port    int    `env:"PORT"`
+		Literal string `env:"LITERAL" envDefault:"${HOST}"`
+	}
+	os.Setenv("APP_PORT", "8080")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Prefix: "APP_"}))
+	assert.Equal(t, "http://localhost:8080", cfg.BaseURL)
+	assert.Equal(t, "${HOST}", cfg.Literal)
+
+	os.Setenv("APP_BASE_URL", "https://example.com")
+	cfg = config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Prefix: "APP_"}))
+	assert.Equal(t, "https://example.com", cfg.BaseURL)
+}
+
+func TestInfer(t *testing.T) {
+	type config struct {
+		Int    interface{} `env:"INT" envInfer:"true"`
+		Bool   interface{} `env:"BOOL" envInfer:"true"`
+		Float  interface{} `env:"FLOAT" envInfer:"true"`
+		String interface{} `env:"STRING" envInfer:"true"`
+		Unset  interface{} `env:"UNSET" envInfer:"true"`
+	}
+	os.Setenv("INT", "42")
+	os.Setenv("BOOL", "true")
+	os.Setenv("FLOAT", "3.14")
+	os.Setenv("STRING", "foo")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Equal(t, 42, cfg.Int)
+	assert.Equal(t, true, cfg.Bool)
+	assert.Equal(t, 3.14, cfg.Float)
+	assert.Equal(t, "foo", cfg.String)
+	assert.Nil(t, cfg.Unset)
+}
+
+func TestInferRequiresEmptyInterface(t *testing.T) {
+	type config struct {
+		Name fmt.Stringer `env:"NAME" envInfer:"true"`
+	}
+	os.Setenv("NAME", "foo")
+	defer os.Clearenv()
+
+	assert.Error(t, Parse(&config{}))
+}
+
+func TestContextParserTimeout(t *testing.T) {
+	type secret string
+	type config struct {
+		Fast secret `env:"FAST" envTimeout:"1s"`
+		Slow secret `env:"SLOW" envTimeout:"10ms"`
+	}
+	os.Setenv("FAST", "fast")
+	os.Setenv("SLOW", "slow")
+	defer os.Clearenv()
+
+	opts := Options{ContextParsers: map[reflect.Type]ContextParserFunc{
+		reflect.TypeOf(secret("")): func(ctx context.Context, v string) (interface{}, error) {
+			if v == "slow" {
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-time.After(time.Second):
+				}
+			}
+			return secret(v), nil
+		},
+	}}
+	cfg := config{}
+	err := ParseWithOptions(&cfg, opts)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "parser for field Slow timed out after 10ms")
+	assert.Equal(t, secret("fast"), cfg.Fast)
+	assert.Equal(t, secret(""), cfg.Slow)
+}
+
+func TestRelativeTime(t *testing.T) {
+	type config struct {
+		Now   time.Time `env:"NOW" envTime:"relative"`
+		Later time.Time `env:"LATER" envTime:"relative"`
+		Ago   time.Time `env:"AGO" envTime:"relative"`
+	}
+	os.Setenv("NOW", "now")
+	os.Setenv("LATER", "now+1h")
+	os.Setenv("AGO", "now-30m")
+	defer os.Clearenv()
+
+	before := time.Now()
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	after := time.Now()
+	assert.False(t, cfg.Now.Before(before) || cfg.Now.After(after))
+	assert.False(t, cfg.Later.Before(before.Add(time.Hour)) || cfg.Later.After(after.Add(time.Hour)))
+	assert.False(t, cfg.Ago.Before(before.Add(-30*time.Minute)) || cfg.Ago.After(after.Add(-30*time.Minute)))
+
+	os.Setenv("LATER", "tomorrow")
+	os.Setenv("AGO", "now*2h")
+	cfg = config{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `env var LATER: unable to parse "tomorrow" into field Later of type time.Time: invalid relative time`)
+	assert.Contains(t, err.Error(), `env var AGO: unable to parse "now*2h" into field Ago of type time.Time: invalid relative time`)
+}
+
+type fakeSecrets map[string]string
+
+func (f fakeSecrets) Resolve(ref string) (string, error) {
+	if value, ok := f[ref]; ok {
+		return value, nil
+	}
+	return "", errors.New("not found")
+}
+
+func TestSecretRef(t *testing.T) {
+	type config struct {
+		User     string `env:"DB_USER"`
+		Password string `env:"DB_PASSWORD" envSecretRef:"db/password"`
+	}
+	os.Setenv("DB_USER", "admin")
+	os.Setenv("DB_PASSWORD", "from-env")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{SecretResolver: fakeSecrets{"db/password": "s3cret"}}))
+	assert.Equal(t, "admin", cfg.User)
+	assert.Equal(t, "s3cret", cfg.Password)
+
+	err := ParseWithOptions(&config{}, Options{SecretResolver: fakeSecrets{}})
+	assert.EqualError(t, err, `unable to resolve secret "db/password" for Password: not found`)
+
+	err = Parse(&config{})
+	assert.EqualError(t, err, `field Password has envSecretRef "db/password" but no SecretResolver is configured`)
+}
+
+func TestAbsoluteURLs(t *testing.T) {
+	type config struct {
+		Home    url.URL   `env:"HOME_URL" envURL:"absolute"`
+		Mirrors []url.URL `env:"MIRRORS" envURL:"absolute"`
+	}
+	os.Setenv("HOME_URL", "https://example.com")
+	os.Setenv("MIRRORS", "https://a.example.com,ftp://b.example.com/pub")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Equal(t, "example.com", cfg.Home.Host)
+	assert.Len(t, cfg.Mirrors, 2)
+
+	os.Setenv("HOME_URL", "/relative")
+	os.Setenv("MIRRORS", "https://a.example.com,b.example.com/pub")
+	cfg = config{}
+	err := Parse(&cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid URL "/relative": expected an absolute URL`)
+	assert.Contains(t, err.Error(), `element 1: invalid URL "b.example.com/pub": expected an absolute URL`)
+}
+
+func TestBigRat(t *testing.T) {
+	type config struct {
+		Tax      big.Rat   `env:"TAX"`
+		Discount *big.Rat  `env:"DISCOUNT"`
+		Rates    []big.Rat `env:"RATES"`
+	}
+	os.Setenv("TAX", "3/7")
+	os.Setenv("DISCOUNT", "0.15")
+	os.Setenv("RATES", "1/2,0.25")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Equal(t, "3/7", cfg.Tax.String())
+	assert.Equal(t, "3/20", cfg.Discount.String())
+	assert.Len(t, cfg.Rates, 2)
+	assert.Equal(t, "1/4", cfg.Rates[1].String())
+
+	os.Setenv("TAX", "3/x")
+	err := Parse(&config{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `env var TAX: unable to parse "3/x" into field Tax of type big.Rat: invalid rational number`)
+}
+
+func TestDeprecatedField(t *testing.T) {
+	type config struct {
+		Workers int    `env:"WORKERS" envDeprecatedField:"use CONCURRENCY instead"`
+		Legacy  string `env:"LEGACY" envDeprecatedField:"no longer used"`
+		Mode    string `env:"MODE"`
+	}
+	os.Setenv("WORKERS", "many")
+	os.Setenv("MODE", "fast")
+	defer os.Clearenv()
+
+	var notices []string
+	OnDeprecatedField = func(field reflect.StructField, key, message string) {
+		notices = append(notices, key+": "+message)
+	}
+	defer func() { OnDeprecatedField = nil }()
+
+	assert.Error(t, Parse(&config{}))
+	assert.Equal(t, []string{"WORKERS: use CONCURRENCY instead"}, notices)
+}
+
+func TestTemplatePasses(t *testing.T) {
+	type config struct {
+		Public  string `env:"PUBLIC_URL" envDefault:"${BASE_URL}/public" envTemplate:"true"`
+		BaseURL string `env:"BASE_URL" envDefault:"http://${HOST}" envTemplate:"true"`
+		Host    string `env:"HOST" envDefault:"localhost"`
+	}
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{TemplatePasses: 2}))
+	assert.Equal(t, "http://localhost", cfg.BaseURL)
+	assert.Equal(t, "http://localhost/public", cfg.Public)
+
+	// without passes, a templated default only sees the templated fields
+	// declared before it
+	cfg = config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, "/public", cfg.Public)
+
+	err := ParseWithOptions(&config{}, Options{TemplatePasses: 1})
+	assert.EqualError(t, err, "templated defaults not resolved after 1 passes: PUBLIC_URL")
+}
+
+func TestTemplatePassesCycle(t *testing.T) {
+	type config struct {
+		A string `env:"A" envDefault:"${B}" envTemplate:"true"`
+		B string `env:"B" envDefault:"${C}" envTemplate:"true"`
+		C string `env:"C" envDefault:"${A}" envTemplate:"true"`
+		D string `env:"D" envDefault:"d" envTemplate:"true"`
+	}
+	defer os.Clearenv()
+
+	err := ParseWithOptions(&config{}, Options{TemplatePasses: 5})
+	assert.EqualError(t, err, "templated defaults form a cycle: A, B, C")
+
+	os.Setenv("C", "c")
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{TemplatePasses: 5}))
+	assert.Equal(t, config{A: "c", B: "c", C: "c", D: "d"}, cfg)
+}
+
+func TestAutoSeparator(t *testing.T) {
+	type config struct {
+		Commas     []string `env:"COMMAS" envSeparator:"auto"`
+		Semicolons []string `env:"SEMICOLONS" envSeparator:"auto"`
+		Spaces     []int    `env:"SPACES" envSeparator:"auto"`
+		Single     []string `env:"SINGLE" envSeparator:"auto"`
+	}
+	os.Setenv("COMMAS", "a, b;c,d")
+	os.Setenv("SEMICOLONS", "a; b c;d")
+	os.Setenv("SPACES", " 1  2\t3 ")
+	os.Setenv("SINGLE", "alone")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, []string{"a", "b;c", "d"}, cfg.Commas)
+	assert.Equal(t, []string{"a", "b c", "d"}, cfg.Semicolons)
+	assert.Equal(t, []int{1, 2, 3}, cfg.Spaces)
+	assert.Equal(t, []string{"alone"}, cfg.Single)
+}
+
+func TestHexColor(t *testing.T) {
+	type config struct {
+		Background imagecolor.RGBA `env:"BACKGROUND"`
+		Overlay    imagecolor.RGBA `env:"OVERLAY"`
+	}
+	os.Setenv("BACKGROUND", "#1E90FF")
+	os.Setenv("OVERLAY", "#00000080")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Equal(t, imagecolor.RGBA{R: 0x1e, G: 0x90, B: 0xff, A: 0xff}, cfg.Background)
+	assert.Equal(t, imagecolor.RGBA{A: 0x80}, cfg.Overlay)
+
+	os.Setenv("BACKGROUND", "1E90FF")
+	os.Setenv("OVERLAY", "#00zz00")
+	err := Parse(&config{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `unable to parse "1E90FF" into field Background of type color.RGBA: expected #RRGGBB or #RRGGBBAA`)
+	assert.Contains(t, err.Error(), `unable to parse "#00zz00" into field Overlay of type color.RGBA: invalid hex color`)
+}
+
+func TestStripComment(t *testing.T) {
+	type config struct {
+		Port    int    `env:"PORT" envStripComment:"true"`
+		Channel string `env:"CHANNEL" envStripComment:"true"`
+		Color   string `env:"COLOR" envStripComment:"true" envDefault:"#fff"`
+		Raw     string `env:"RAW"`
+	}
+	os.Setenv("PORT", "8080 # default")
+	os.Setenv("CHANNEL", `\#general # team chat`)
+	os.Setenv("RAW", "a # b")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, 8080, cfg.Port)
+	assert.Equal(t, "#general", cfg.Channel)
+	assert.Equal(t, "#fff", cfg.Color)
+	assert.Equal(t, "a # b", cfg.Raw)
+}
+
+func TestLazy(t *testing.T) {
+	type config struct {
+		Token  func() string `env:"TOKEN" envLazy:"true"`
+		Region func() string `env:"REGION" envLazy:"true" envDefault:"us-east-1"`
+	}
+	os.Setenv("TOKEN", "first")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Equal(t, "first", cfg.Token())
+	assert.Equal(t, "us-east-1", cfg.Region())
+
+	os.Setenv("TOKEN", "rotated")
+	os.Setenv("REGION", "eu-west-1")
+	assert.Equal(t, "rotated", cfg.Token())
+	assert.Equal(t, "eu-west-1", cfg.Region())
+
+	type badConfig struct {
+		Token func() int `env:"TOKEN" envLazy:"true"`
+	}
+	assert.Error(t, Parse(&badConfig{}))
+}
+
+func TestLazyRequired(t *testing.T) {
+	type config struct {
+		Token func() string `env:"TOKEN" envLazy:"true" required:"true"`
+		Key   func() string `env:"KEY" envLazy:"true" envDeprecated:"OLD_KEY"`
+	}
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.EqualError(t, Parse(&cfg), "missing required env var: TOKEN")
+	assert.Nil(t, cfg.Token)
+
+	os.Setenv("DB_TOKEN", "fallback")
+	os.Setenv("OLD_KEY", "old")
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Prefix: "SERVICE_DB_", PrefixFallback: true}))
+	assert.Equal(t, "fallback", cfg.Token())
+	assert.Equal(t, "", cfg.Key())
+
+	cfg = config{}
+	os.Setenv("TOKEN", "t")
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, "old", cfg.Key())
+}
+
+func TestNonNegativeDuration(t *testing.T) {
+	type config struct {
+		Timeout time.Duration `env:"TIMEOUT" envDuration:"nonnegative"`
+		Offset  time.Duration `env:"OFFSET"`
+		Window  time.Duration `env:"WINDOW" envDuration:"extended,nonnegative"`
+	}
+	os.Setenv("TIMEOUT", "5s")
+	os.Setenv("OFFSET", "-5s")
+	os.Setenv("WINDOW", "1d")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, 5*time.Second, cfg.Timeout)
+	assert.Equal(t, -5*time.Second, cfg.Offset)
+	assert.Equal(t, 24*time.Hour, cfg.Window)
+
+	os.Setenv("TIMEOUT", "-5s")
+	os.Setenv("WINDOW", "-1d")
+	err := Parse(&config{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `negative duration "-5s" for Timeout: expected zero or more`)
+	assert.Contains(t, err.Error(), `negative duration "-1d" for Window: expected zero or more`)
+}
+
+func TestSets(t *testing.T) {
+	type config struct {
+		Features map[string]struct{} `env:"FEATURES"`
+		Ports    map[int]struct{}    `env:"PORTS" envSeparator:" "`
+	}
+	os.Setenv("FEATURES", "search,beta,search")
+	os.Setenv("PORTS", "80 443 80")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Len(t, cfg.Features, 2)
+	_, ok := cfg.Features["beta"]
+	assert.True(t, ok)
+	_, ok = cfg.Features["alpha"]
+	assert.False(t, ok)
+	assert.Equal(t, map[int]struct{}{80: {}, 443: {}}, cfg.Ports)
+
+	os.Setenv("PORTS", "80 http")
+	err := Parse(&config{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `unable to parse "http" into field Ports of type map[int]struct {}: invalid map key`)
+}
+
+func TestSlogLevel(t *testing.T) {
+	type config struct {
+		Level slog.Level `env:"LEVEL"`
+	}
+	defer os.Clearenv()
+
+	for value, want := range map[string]slog.Level{
+		"debug":  slog.LevelDebug,
+		"INFO":   slog.LevelInfo,
+		"Warn":   slog.LevelWarn,
+		"error":  slog.LevelError,
+		"info+2": slog.LevelInfo + 2,
+		"-8":     slog.Level(-8),
+	} {
+		os.Setenv("LEVEL", value)
+		cfg := config{}
+		assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}), value)
+		assert.Equal(t, want, cfg.Level, value)
+	}
+
+	os.Setenv("LEVEL", "verbose")
+	err := Parse(&config{})
+	assert.EqualError(t, err, `env var LEVEL: unable to parse "verbose" into field Level of type slog.Level: unknown log level, expected debug, info, warn or error`)
+}
+
+func TestPrefixFallback(t *testing.T) {
+	type config struct {
+		Host string `env:"HOST"`
+		Port int    `env:"PORT"`
+		User string `env:"USER"`
+		Name string `env:"NAME"`
+	}
+	os.Setenv("SERVICE_DB_HOST", "service-db")
+	os.Setenv("DB_HOST", "db")
+	os.Setenv("HOST", "host")
+	os.Setenv("DB_PORT", "5432")
+	os.Setenv("PORT", "80")
+	os.Setenv("USER", "admin")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Prefix: "SERVICE_DB_", PrefixFallback: true}))
+	assert.Equal(t, config{Host: "service-db", Port: 5432, User: "admin"}, cfg)
+
+	cfg = config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Prefix: "SERVICE_DB_"}))
+	assert.Equal(t, config{Host: "service-db"}, cfg)
+
+	os.Setenv("SERVICE.DB.NAME", "dotted")
+	os.Setenv("DB.NAME", "db-dotted")
+	cfg = config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Prefix: "APP.DB.", PrefixFallback: true, PrefixDelimiter: "."}))
+	assert.Equal(t, "db-dotted", cfg.Name)
+}
+
+func TestRange(t *testing.T) {
+	type durationRange struct {
+		Min, Max time.Duration
+	}
+	type intRange struct {
+		Min int
+		Max int
+	}
+	type config struct {
+		Backoff durationRange `env:"RETRY_BACKOFF" envRange:"true"`
+		Workers intRange      `env:"WORKERS" envRange:"true"`
+	}
+	os.Setenv("RETRY_BACKOFF", "1s..30s")
+	os.Setenv("WORKERS", "-2..8")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Equal(t, durationRange{Min: time.Second, Max: 30 * time.Second}, cfg.Backoff)
+	assert.Equal(t, intRange{Min: -2, Max: 8}, cfg.Workers)
+
+	os.Setenv("RETRY_BACKOFF", "30s..1s")
+	os.Setenv("WORKERS", "4")
+	err := Parse(&config{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid range "30s..1s" for Backoff: Min is greater than Max`)
+	assert.Contains(t, err.Error(), `invalid range "4" for Workers: expected MIN..MAX`)
+}
+
+func TestScalarPointers(t *testing.T) {
+	type config struct {
+		Int     *int     `env:"INT"`
+		Int64   *int64   `env:"INT64"`
+		Float64 *float64 `env:"FLOAT64"`
+		Uint    *uint    `env:"UINT"`
+		Unset   *int     `env:"UNSET"`
+		Zero    *int     `env:"ZERO"`
+	}
+	os.Setenv("INT", "-42")
+	os.Setenv("INT64", "9000000000")
+	os.Setenv("FLOAT64", "2.5")
+	os.Setenv("UINT", "7")
+	os.Setenv("ZERO", "0")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	if assert.NotNil(t, cfg.Int) {
+		assert.Equal(t, -42, *cfg.Int)
+	}
+	if assert.NotNil(t, cfg.Int64) {
+		assert.Equal(t, int64(9000000000), *cfg.Int64)
+	}
+	if assert.NotNil(t, cfg.Float64) {
+		assert.Equal(t, 2.5, *cfg.Float64)
+	}
+	if assert.NotNil(t, cfg.Uint) {
+		assert.Equal(t, uint(7), *cfg.Uint)
+	}
+	assert.Nil(t, cfg.Unset)
+	if assert.NotNil(t, cfg.Zero) {
+		assert.Equal(t, 0, *cfg.Zero)
+	}
+
+	os.Clearenv()
+	cfg = config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, config{}, cfg)
+
+	os.Setenv("INT64", "99999999999999999999")
+	os.Setenv("UINT", "-1")
+	err := Parse(&config{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `unable to parse "99999999999999999999" into field Int64 of type int64`)
+	assert.Contains(t, err.Error(), `unable to parse "-1" into field Uint of type uint`)
+}
+
+func TestTreatBlankAsUnset(t *testing.T) {
+	type config struct {
+		Host string `env:"HOST" envDefault:"localhost"`
+		Name string `env:"NAME"`
+	}
+	os.Setenv("HOST", "   ")
+	os.Setenv("NAME", "\t")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{TreatBlankAsUnset: true}))
+	assert.Equal(t, config{Host: "localhost"}, cfg)
+
+	cfg = config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, config{Host: "   ", Name: "\t"}, cfg)
+
+	type requiredConfig struct {
+		Token string `env:"TOKEN" required:"true"`
+	}
+	os.Setenv("TOKEN", " ")
+	err := ParseWithOptions(&requiredConfig{}, Options{TreatBlankAsUnset: true})
+	assert.EqualError(t, err, "missing required env var: TOKEN")
+	assert.Equal(t, []string{"TOKEN"}, Missing(&requiredConfig{}, Options{TreatBlankAsUnset: true}))
+	assert.Empty(t, Missing(&requiredConfig{}, Options{}))
+
+	type renamedConfig struct {
+		Token string `env:"TOKEN" envDeprecated:"OLD_TOKEN"`
+		Inner *InnerStruct
+	}
+	os.Setenv("OLD_TOKEN", "old")
+	os.Setenv("innervar", " ")
+	renamed := renamedConfig{}
+	assert.NoError(t, ParseWithOptions(&renamed, Options{TreatBlankAsUnset: true}))
+	assert.Equal(t, renamedConfig{Token: "old"}, renamed)
+}
+
+func TestMailAddress(t *testing.T) {
+	type config struct {
+		From    mail.Address   `env:"FROM"`
+		ReplyTo *mail.Address  `env:"REPLY_TO"`
+		To      []mail.Address `env:"TO" envSeparator:";"`
+		Unset   *mail.Address  `env:"UNSET"`
+	}
+	os.Setenv("FROM", "Alerts <alerts@example.com>")
+	os.Setenv("REPLY_TO", "ops@example.com")
+	os.Setenv("TO", `"Doe, Jane" <jane@example.com>; bob@example.com`)
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Equal(t, mail.Address{Name: "Alerts", Address: "alerts@example.com"}, cfg.From)
+	assert.Equal(t, &mail.Address{Address: "ops@example.com"}, cfg.ReplyTo)
+	assert.Equal(t, []mail.Address{{Name: "Doe, Jane", Address: "jane@example.com"}, {Address: "bob@example.com"}}, cfg.To)
+	assert.Nil(t, cfg.Unset)
+
+	os.Setenv("FROM", "not an address")
+	os.Setenv("TO", "bob@example.com;oops")
+	err := Parse(&config{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `unable to parse "not an address" into field From of type mail.Address`)
+	assert.Contains(t, err.Error(), `invalid address "oops"`)
+}
+
+type tenantID [16]byte
+
+func TestUUIDFormat(t *testing.T) {
+	type config struct {
+		Tenant  tenantID `env:"TENANT" envFormat:"uuid"`
+		Request [16]byte `env:"REQUEST" envFormat:"uuid"`
+	}
+	os.Setenv("TENANT", "123e4567-e89b-12d3-a456-426614174000")
+	os.Setenv("REQUEST", "00000000-0000-0000-0000-0000000000FF")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	assert.Equal(t, tenantID{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}, cfg.Tenant)
+	assert.Equal(t, byte(0xff), cfg.Request[15])
+
+	os.Setenv("TENANT", "123e4567e89b12d3a456426614174000")
+	os.Setenv("REQUEST", "123e4567-e89b-12d3-a456-42661417400g")
+	err := Parse(&config{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid UUID "123e4567e89b12d3a456426614174000": expected the 8-4-4-4-12 hex form`)
+	assert.Contains(t, err.Error(), `invalid UUID "123e4567-e89b-12d3-a456-42661417400g"`)
+}
+
+func TestValidateUUID(t *testing.T) {
+	assert.NoError(t, ValidateUUID("123e4567-e89b-12d3-a456-426614174000"))
+	assert.Error(t, ValidateUUID("123e4567-e89b-12d3-a456"))
+	assert.Error(t, ValidateUUID("123e4567-e89b-12d3-a456-4266141740-0"))
+}
+
+func TestDurationSum(t *testing.T) {
+	type config struct {
+		Timeout time.Duration `env:"TIMEOUT" envDuration:"sum"`
+		Window  time.Duration `env:"WINDOW" envDuration:"extended,sum"`
+	}
+	os.Setenv("TIMEOUT", "1m+30s")
+	os.Setenv("WINDOW", "1d + 12h")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, 90*time.Second, cfg.Timeout)
+	assert.Equal(t, 36*time.Hour, cfg.Window)
+
+	os.Setenv("TIMEOUT", "1m+soon")
+	os.Setenv("WINDOW", "1d+")
+	err := Parse(&config{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `env var TIMEOUT: unable to parse "1m+soon" into field Timeout of type time.Duration: invalid part "soon" of duration sum`)
+	assert.Contains(t, err.Error(), `env var WINDOW: unable to parse "1d+" into field Window of type time.Duration: invalid part "" of duration sum`)
+}
+
+func TestIPNets(t *testing.T) {
+	type config struct {
+		Allow   []net.IPNet `env:"ALLOW"`
+		Network net.IPNet   `env:"NETWORK"`
+	}
+	os.Setenv("ALLOW", "10.0.0.0/8, 192.168.0.0/16,2001:db8::/32")
+	os.Setenv("NETWORK", "172.16.5.4/12")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Strict: true}))
+	if assert.Len(t, cfg.Allow, 3) {
+		assert.Equal(t, "10.0.0.0/8", cfg.Allow[0].String())
+		assert.Equal(t, "192.168.0.0/16", cfg.Allow[1].String())
+		assert.Equal(t, "2001:db8::/32", cfg.Allow[2].String())
+		assert.True(t, cfg.Allow[1].Contains(net.ParseIP("192.168.1.1")))
+	}
+	assert.Equal(t, "172.16.0.0/12", cfg.Network.String())
+
+	os.Setenv("ALLOW", "10.0.0.0/8,10.0.0.0/33")
+	os.Setenv("NETWORK", "10.0.0.1")
+	err := Parse(&config{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `env var ALLOW: unable to parse "10.0.0.0/33" into field Allow of type []net.IPNet: invalid CIDR address: 10.0.0.0/33`)
+	assert.Contains(t, err.Error(), `unable to parse "10.0.0.1" into field Network of type net.IPNet`)
+}
+
+func TestParseWithProvenance(t *testing.T) {
+	type database struct {
+		Host     string `env:"HOST"`
+		Password string `env:"PASSWORD" envSecretRef:"db/password"`
+	}
+	type config struct {
+		Port   int      `env:"PORT"`
+		Level  string   `env:"LEVEL"`
+		Region string   `env:"REGION" envDefault:"eu-west-1"`
+		Token  string   `env:"TOKEN"`
+		Name   string   `env:"NAME" envDeprecated:"APP_NAME"`
+		Unset  string   `env:"UNSET"`
+		DB     database `envPrefix:"DB_"`
+	}
+	os.Setenv("LEVEL", "debug")
+	os.Setenv("DB_HOST", "db.internal")
+	os.Setenv("TOKEN", "vault://app/token")
+	os.Setenv("APP_NAME", "svc")
+	defer os.Clearenv()
+
+	flags := MapSource{"PORT": "9000"}
+	file := MapSource{"PORT": "8080", "LEVEL": "info"}
+	cfg := config{}
+	provenance, err := ParseWithProvenance(&cfg, Options{
+		Sources:        []Source{flags, file},
+		SecretResolver: fakeSecrets{"db/password": "s3cret"},
+		Resolvers: map[string]func(string) (string, error){
+			"vault": func(string) (string, error) { return "t0ken", nil },
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 9000, cfg.Port)
+	assert.Equal(t, "info", cfg.Level)
+	assert.Equal(t, map[string]Provenance{
+		"Port":        {Origin: OriginSource, Source: 0, Key: "PORT"},
+		"Level":       {Origin: OriginSource, Source: 1, Key: "LEVEL"},
+		"Region":      {Origin: OriginDefault, Source: -1, Key: "REGION"},
+		"Token":       {Origin: OriginEnv, Source: -1, Key: "TOKEN", Resolver: "vault"},
+		"Name":        {Origin: OriginEnv, Source: -1, Key: "APP_NAME"},
+		"DB.Host":     {Origin: OriginEnv, Source: -1, Key: "DB_HOST"},
+		"DB.Password": {Origin: OriginSecret, Source: -1, Key: "db/password"},
+	}, provenance)
+}
+
+func TestParseWithProvenanceRawKeys(t *testing.T) {
+	type config struct {
+		Host  string        `env:"db_host"`
+		Port  string        `env:"APP_PORT"`
+		Token func() string `env:"TOKEN" envLazy:"true"`
+	}
+	os.Setenv("DB_HOST", "db.internal")
+	os.Setenv("TOKEN", "t0ken")
+	defer os.Clearenv()
+
+	cfg := config{}
+	provenance, err := ParseWithProvenance(&cfg, Options{
+		NormalizeKey: strings.ToUpper,
+		KeyRewrite:   func(key string) string { return strings.Replace(key, "APP_", "LEGACY_", 1) },
+		Sources:      []Source{MapSource{"LEGACY_PORT": "8080"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]Provenance{
+		"Host":  {Origin: OriginEnv, Source: -1, Key: "DB_HOST"},
+		"Port":  {Origin: OriginSource, Source: 0, Key: "LEGACY_PORT"},
+		"Token": {Origin: OriginEnv, Source: -1, Key: "TOKEN"},
+	}, provenance)
+}
+
+func TestMultiline(t *testing.T) {
+	type config struct {
+		Cert  string `env:"TLS_CERT" envMultiline:"true"`
+		Plain string `env:"PLAIN"`
+	}
+	os.Setenv("TLS_CERT", `-----BEGIN CERTIFICATE-----\nMIIBszCCAVmgAwIBAgI=\n-----END CERTIFICATE-----`)
+	os.Setenv("PLAIN", `a\nb`)
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, "-----BEGIN CERTIFICATE-----\nMIIBszCCAVmgAwIBAgI=\n-----END CERTIFICATE-----", cfg.Cert)
+	assert.Len(t, strings.Split(cfg.Cert, "\n"), 3)
+	assert.Equal(t, `a\nb`, cfg.Plain)
+}
+
+func TestTimeLayouts(t *testing.T) {
+	type config struct {
+		Holidays []time.Time `env:"HOLIDAYS" envLayouts:"RFC3339,DateOnly"`
+		Plain    []time.Time `env:"PLAIN"`
+	}
+	os.Setenv("HOLIDAYS", "2026-12-25T00:00:00Z, 2027-01-01")
+	os.Setenv("PLAIN", "2026-12-25T00:00:00Z")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, Parse(&cfg))
+	assert.Equal(t, []time.Time{
+		time.Date(2026, 12, 25, 0, 0, 0, 0, time.UTC),
+		time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+	}, cfg.Holidays)
+	assert.Len(t, cfg.Plain, 1)
+
+	os.Setenv("HOLIDAYS", "2026-12-25T00:00:00Z,2027-01-01,01/02/2027")
+	err := Parse(&config{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `time "01/02/2027" matches none of the layouts "RFC3339,DateOnly"`)
+
+	os.Setenv("HOLIDAYS", "2027-01-01")
+	os.Setenv("PLAIN", "2027-01-01")
+	assert.Error(t, Parse(&config{}))
+}
+
+func TestKeyRewrite(t *testing.T) {
+	type config struct {
+		Host  string `env:"APP_HOST" required:"true"`
+		Port  int    `env:"APP_PORT" envDefault:"80"`
+		Debug bool   `env:"DEBUG"`
+	}
+	os.Setenv("LEGACY_HOST", "legacy.internal")
+	os.Setenv("APP_HOST", "ignored")
+	os.Setenv("DEBUG", "true")
+	defer os.Clearenv()
+
+	legacy := func(key string) string {
+		if strings.HasPrefix(key, "APP_") {
+			return "LEGACY_" + strings.TrimPrefix(key, "APP_")
+		}
+		return key
+	}
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{KeyRewrite: legacy}))
+	assert.Equal(t, config{Host: "legacy.internal", Port: 80, Debug: true}, cfg)
+
+	os.Unsetenv("LEGACY_HOST")
+	assert.Equal(t, []string{"APP_HOST"}, Missing(&config{}, Options{KeyRewrite: legacy}))
+}
+
+func TestAllocatedPointers(t *testing.T) {
+	type endpoint struct {
+		host string
+	}
+	type config struct {
+		Zone    *time.Location `env:"TZ"`
+		Tax     *big.Rat       `env:"TAX"`
+		From    *mail.Address  `env:"FROM"`
+		Backend *endpoint      `env:"BACKEND"`
+		Default *time.Location `env:"DEFAULT_TZ"`
+	}
+	os.Setenv("TZ", "America/New_York")
+	os.Setenv("TAX", "3/7")
+	os.Setenv("FROM", "Alerts <alerts@example.com>")
+	os.Setenv("BACKEND", "api.internal")
+	defer os.Clearenv()
+
+	cfg := config{
+		Zone:    time.UTC,
+		Tax:     big.NewRat(1, 2),
+		From:    &mail.Address{Address: "root@localhost"},
+		Backend: &endpoint{host: "localhost"},
+		Default: time.UTC,
+	}
+	opts := Options{Parsers: CustomParsers{reflect.TypeOf(&endpoint{}): func(v string) (interface{}, error) {
+		return &endpoint{host: v}, nil
+	}}}
+	assert.NoError(t, ParseWithOptions(&cfg, opts))
+	assert.Equal(t, "America/New_York", cfg.Zone.String())
+	assert.Equal(t, "3/7", cfg.Tax.String())
+	assert.Equal(t, &mail.Address{Name: "Alerts", Address: "alerts@example.com"}, cfg.From)
+	assert.Equal(t, &endpoint{host: "api.internal"}, cfg.Backend)
+	assert.Equal(t, time.UTC, cfg.Default)
+}
@@ -0,0 +1,135 @@
+package env
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// evalExpr evaluates an integer arithmetic expression built from + - * /,
+// unary minus and parentheses.  Nothing else is accepted, so evaluating a
+// value can never do more than arithmetic.
+func evalExpr(expr string) (int64, error) {
+	p := &exprParser{input: strings.Replace(expr, " ", "", -1)}
+	n, err := p.parseSum()
+	if err != nil {
+		return 0, fmt.Errorf("invalid expression %q: %v", expr, err)
+	}
+	if p.pos != len(p.input) {
+		return 0, fmt.Errorf("invalid expression %q: unexpected %q", expr, p.input[p.pos:])
+	}
+	return n, nil
+}
+
+// errOverflow is returned when an intermediate result does not fit in an int64.
+var errOverflow = errors.New("integer overflow")
+
+type exprParser struct {
+	input string
+	pos   int
+}
+
+func (p *exprParser) peek() byte {
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
+
+// parseSum handles the lowest precedence operators, + and -.
+func (p *exprParser) parseSum() (int64, error) {
+	n, err := p.parseProduct()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return n, nil
+		}
+		p.pos++
+		m, err := p.parseProduct()
+		if err != nil {
+			return 0, err
+		}
+		if op == '-' {
+			if m == math.MinInt64 {
+				return 0, errOverflow
+			}
+			m = -m
+		}
+		if (m > 0 && n > math.MaxInt64-m) || (m < 0 && n < math.MinInt64-m) {
+			return 0, errOverflow
+		}
+		n += m
+	}
+}
+
+// parseProduct handles * and /.
+func (p *exprParser) parseProduct() (int64, error) {
+	n, err := p.parseFactor()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' {
+			return n, nil
+		}
+		p.pos++
+		m, err := p.parseFactor()
+		if err != nil {
+			return 0, err
+		}
+		if op == '*' {
+			if n != 0 && ((n == -1 && m == math.MinInt64) || (m == -1 && n == math.MinInt64) || n*m/n != m) {
+				return 0, errOverflow
+			}
+			n *= m
+		} else {
+			if m == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			if n == math.MinInt64 && m == -1 {
+				return 0, errOverflow
+			}
+			n /= m
+		}
+	}
+}
+
+// parseFactor handles integers, unary minus and parenthesized expressions.
+func (p *exprParser) parseFactor() (int64, error) {
+	switch c := p.peek(); {
+	case c == '-':
+		p.pos++
+		n, err := p.parseFactor()
+		if err == nil && n == math.MinInt64 {
+			return 0, errOverflow
+		}
+		return -n, err
+	case c == '(':
+		p.pos++
+		n, err := p.parseSum()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return n, nil
+	case c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos < len(p.input) && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+			p.pos++
+		}
+		return strconv.ParseInt(p.input[start:p.pos], 10, 64)
+	case c == 0:
+		return 0, fmt.Errorf("unexpected end of expression")
+	default:
+		return 0, fmt.Errorf("unexpected %q", string(c))
+	}
+}
@@ -0,0 +1,37 @@
+package env
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvalExpr(t *testing.T) {
+	cases := map[string]int64{
+		"42":                      42,
+		"60*60":                   3600,
+		"(1+2)*3":                 9,
+		"1 + 2 * 3":               7,
+		"10/3":                    3,
+		"-(2-5)":                  3,
+		"2*(3+(4-1))":             12,
+		"9223372036854775807":     9223372036854775807,
+		"-9223372036854775807-1":  -9223372036854775808,
+		"-4611686018427387904*2":  -9223372036854775808,
+		"9223372036854775807-1+1": 9223372036854775807,
+	}
+	for expr, expected := range cases {
+		n, err := evalExpr(expr)
+		assert.NoError(t, err, expr)
+		assert.Equal(t, expected, n, expr)
+	}
+}
+
+func TestEvalExprErrors(t *testing.T) {
+	for _, expr := range []string{"", "1+", "(1+2", "1+2)", "2**3", "1/0", "1.5*2", "os.Exit(1)",
+		"9223372036854775807+1", "-9223372036854775807-2", "0-9223372036854775807-2", "4611686018427387904*2",
+		"-4611686018427387905*2", "3037000500*3037000500"} {
+		_, err := evalExpr(expr)
+		assert.Error(t, err, expr)
+	}
+}
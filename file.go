@@ -59,6 +59,18 @@ func MustLoad(filenames ...string) {
 	}
 }
 
+// LoadFileIfExists is the same as Load for a single file, except that a file
+// that doesn't exist is not an error.  This suits a .env file that is present
+// in development but absent in production.  A file that exists but can't be
+// read or parsed is still reported.
+func LoadFileIfExists(filename string) error {
+	err := loadFile(filename, false)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
 // Overload will read your env file(s) and load them into ENV for this process.
 //
 // Call this function as close as possible to the start of your program (ideally in main)
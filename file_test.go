@@ -476,3 +476,16 @@ func TestRoundtrip(t *testing.T) {
 
 	}
 }
+
+func TestLoadFileIfExists(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+
+	assert.NoError(t, LoadFileIfExists("fixtures/does-not-exist.env"))
+	assert.Empty(t, os.Environ())
+
+	assert.NoError(t, LoadFileIfExists("fixtures/plain.env"))
+	assert.Equal(t, "1", os.Getenv("OPTION_A"))
+
+	assert.Error(t, LoadFileIfExists("fixtures/invalid1.env"))
+}
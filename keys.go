@@ -0,0 +1,139 @@
+package env
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Keys returns the env var keys read by the struct v points to, in field
+// order and including those of nested structs.  A key is listed once for
+// every field that reads it.
+func Keys(v interface{}, opts Options) []string {
+	var keys []string
+	walkKeys(v, opts, func(_ reflect.Type, field reflect.StructField, prefix, _ string) {
+		keys = append(keys, prefix+field.Tag.Get("env"))
+	})
+	return keys
+}
+
+// ValidateKeys reports an error listing every key that is read by more than
+// one field, which usually means a tag was copied and not renamed.
+func ValidateKeys(v interface{}, opts Options) error {
+	var order []string
+	fields := map[string][]string{}
+	walkKeys(v, opts, func(_ reflect.Type, field reflect.StructField, prefix, path string) {
+		key := prefix + field.Tag.Get("env")
+		if _, ok := fields[key]; !ok {
+			order = append(order, key)
+		}
+		fields[key] = append(fields[key], path)
+	})
+	var collisions []string
+	for _, key := range order {
+		if len(fields[key]) > 1 {
+			collisions = append(collisions, fmt.Sprintf("%s (%s)", key, strings.Join(fields[key], ", ")))
+		}
+	}
+	if len(collisions) > 0 {
+		return fmt.Errorf("duplicate env var keys: %s", strings.Join(collisions, "; "))
+	}
+	return nil
+}
+
+// Missing returns the keys of the required env vars that are unset for the
+// struct v points to, in field order.  Values are neither parsed nor
+// assigned, so it is a cheap way to report every missing variable at startup.
+func Missing(v interface{}, opts Options) []string {
+	var keys []string
+	walkKeys(v, opts, func(owner reflect.Type, field reflect.StructField, prefix, _ string) {
+		key := prefix + field.Tag.Get("env")
+		required, _ := strconv.ParseBool(field.Tag.Get("required"))
+		if cond := field.Tag.Get("envRequiredIf"); !required && cond != "" {
+			required, _ = requiredIf(cond, prefix, owner, opts)
+		}
+		if !required || field.Tag.Get("envSecretRef") != "" {
+			// secret references come from Options.SecretResolver, not the
+			// environment
+			return
+		}
+		if _, _, _, ok := lookupField(field, prefix, opts); ok {
+			return
+		}
+		keys = append(keys, key)
+	})
+	return keys
+}
+
+// reportUnusedParsers calls Options.OnUnusedParser, in order of type name,
+// for the custom parsers whose type is not used by any tagged field.  The
+// element types of maps, slices and pointers count as used too.
+func reportUnusedParsers(v interface{}, opts Options) {
+	used := map[reflect.Type]bool{}
+	walkKeys(v, opts, func(_ reflect.Type, field reflect.StructField, _, _ string) {
+		used[field.Type] = true
+		switch field.Type.Kind() {
+		case reflect.Map, reflect.Slice, reflect.Ptr:
+			used[field.Type.Elem()] = true
+		}
+	})
+	var unused []reflect.Type
+	for t := range opts.Parsers {
+		if !used[t] {
+			unused = append(unused, t)
+		}
+	}
+	sort.Slice(unused, func(i, j int) bool { return unused[i].String() < unused[j].String() })
+	for _, t := range unused {
+		opts.OnUnusedParser(t)
+	}
+}
+
+// walkKeys calls fn for every tagged field of the struct type behind v, in
+// the order Parse visits them, with the prefix of the field's key and its path
+// from the top-level struct, such as DB.Host.
+func walkKeys(v interface{}, opts Options, fn func(owner reflect.Type, field reflect.StructField, prefix, path string)) {
+	refType := reflect.TypeOf(v)
+	for refType != nil && refType.Kind() == reflect.Ptr {
+		refType = refType.Elem()
+	}
+	if refType == nil || refType.Kind() != reflect.Struct {
+		return
+	}
+	walkKeysVisit(refType, expandPrefix(opts.Prefix, opts), "", opts, map[reflect.Type]bool{}, fn)
+}
+
+func walkKeysVisit(refType reflect.Type, prefix, path string, opts Options, visited map[reflect.Type]bool, fn func(reflect.Type, reflect.StructField, string, string)) {
+	if visited[refType] {
+		return
+	}
+	visited[refType] = true
+	defer delete(visited, refType)
+
+	for i := 0; i < refType.NumField(); i++ {
+		refTypeField := patternField(refType.Field(i), opts)
+		fieldPrefix := prefix + refTypeField.Tag.Get("envPrefix")
+		if refTypeField.PkgPath != "" && !refTypeField.Anonymous {
+			continue
+		}
+		opts := opts
+		var skip bool
+		if opts.Group, skip = fieldGroup(refTypeField, opts.Group); skip {
+			continue
+		}
+		fieldPath := path + refTypeField.Name
+		if _, hasTag := refTypeField.Tag.Lookup("env"); !hasTag {
+			fieldType := refTypeField.Type
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				walkKeysVisit(fieldType, fieldPrefix, fieldPath+".", opts, visited, fn)
+			}
+			continue
+		}
+		fn(refType, refTypeField, fieldPrefix, fieldPath)
+	}
+}
@@ -0,0 +1,181 @@
+package env
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMissing(t *testing.T) {
+	type database struct {
+		Host string `env:"DB_HOST" required:"true"`
+		Name string `env:"DB_NAME" required:"true"`
+	}
+	type config struct {
+		Token   string `env:"TOKEN" required:"true"`
+		Region  string `env:"REGION" required:"true" envDefault:"us-east-1"`
+		Legacy  string `env:"API_KEY" required:"true" envDeprecated:"OLD_API_KEY"`
+		Verbose bool   `env:"VERBOSE"`
+		DB      *database
+	}
+	os.Setenv("APP_DB_HOST", "localhost")
+	os.Setenv("APP_OLD_API_KEY", "secret")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.Equal(t, []string{"APP_TOKEN", "APP_REGION", "APP_DB_NAME"}, Missing(&cfg, Options{Prefix: "APP_"}))
+	assert.Equal(t, config{}, cfg)
+
+	os.Setenv("APP_TOKEN", "t")
+	os.Setenv("APP_REGION", "eu-west-1")
+	os.Setenv("APP_DB_NAME", "app")
+	assert.Empty(t, Missing(&cfg, Options{Prefix: "APP_"}))
+	assert.Nil(t, Missing(42, Options{}))
+}
+
+func TestKeys(t *testing.T) {
+	type database struct {
+		Host string `env:"DB_HOST"`
+	}
+	type config struct {
+		Name    string `env:"NAME"`
+		Ignored string
+		DB      *database
+		Port    int `env:"PORT"`
+	}
+	assert.Equal(t, []string{"APP_NAME", "APP_DB_HOST", "APP_PORT"}, Keys(&config{}, Options{Prefix: "APP_"}))
+	assert.Nil(t, Keys("not a struct", Options{}))
+}
+
+func TestValidateKeys(t *testing.T) {
+	type database struct {
+		Host string `env:"HOST"`
+		Port int    `env:"PORT"`
+	}
+	type config struct {
+		Host  string `env:"HOST"`
+		Name  string `env:"NAME"`
+		Alias string `env:"NAME"`
+		DB    database
+	}
+	err := ValidateKeys(&config{}, Options{})
+	assert.EqualError(t, err, "duplicate env var keys: HOST (Host, DB.Host); NAME (Name, Alias)")
+
+	type unique struct {
+		Name string `env:"NAME"`
+		DB   database
+	}
+	assert.NoError(t, ValidateKeys(unique{}, Options{}))
+}
+
+func TestUnusedParsers(t *testing.T) {
+	type legacy struct{ Name string }
+	type current struct{ Name string }
+	type config struct {
+		Current current   `env:"CURRENT"`
+		List    []current `env:"LIST"`
+	}
+	os.Setenv("CURRENT", "now")
+	defer os.Clearenv()
+
+	var unused []reflect.Type
+	opts := Options{
+		Parsers: CustomParsers{
+			reflect.TypeOf(current{}): func(v string) (interface{}, error) { return current{v}, nil },
+			reflect.TypeOf(legacy{}):  func(v string) (interface{}, error) { return legacy{v}, nil },
+			reflect.TypeOf(0):         func(v string) (interface{}, error) { return 0, nil },
+		},
+		OnUnusedParser: func(t reflect.Type) { unused = append(unused, t) },
+	}
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, opts))
+	assert.Equal(t, current{"now"}, cfg.Current)
+	assert.Equal(t, []reflect.Type{reflect.TypeOf(legacy{}), reflect.TypeOf(0)}, unused)
+}
+
+func TestFieldPrefix(t *testing.T) {
+	type endpoint struct {
+		Host string `env:"HOST"`
+		Port int    `env:"PORT" required:"true"`
+	}
+	type config struct {
+		DBHost    string    `env:"HOST" envPrefix:"DB_"`
+		CacheHost string    `env:"HOST" envPrefix:"CACHE_"`
+		Admin     endpoint  `envPrefix:"ADMIN_"`
+		Public    *endpoint `envPrefix:"PUBLIC_"`
+	}
+	os.Setenv("APP_DB_HOST", "db")
+	os.Setenv("APP_CACHE_HOST", "cache")
+	os.Setenv("APP_ADMIN_HOST", "admin")
+	os.Setenv("APP_ADMIN_PORT", "9000")
+	os.Setenv("APP_PUBLIC_PORT", "443")
+	defer os.Clearenv()
+
+	opts := Options{Prefix: "APP_"}
+	assert.Equal(t, []string{
+		"APP_DB_HOST", "APP_CACHE_HOST", "APP_ADMIN_HOST", "APP_ADMIN_PORT", "APP_PUBLIC_HOST", "APP_PUBLIC_PORT",
+	}, Keys(&config{}, opts))
+	assert.NoError(t, ValidateKeys(&config{}, opts))
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, opts))
+	assert.Equal(t, "db", cfg.DBHost)
+	assert.Equal(t, "cache", cfg.CacheHost)
+	assert.Equal(t, endpoint{Host: "admin", Port: 9000}, cfg.Admin)
+	assert.Equal(t, &endpoint{Port: 443}, cfg.Public)
+
+	os.Unsetenv("APP_ADMIN_PORT")
+	assert.Equal(t, []string{"APP_ADMIN_PORT"}, Missing(&cfg, opts))
+}
+
+func TestGroup(t *testing.T) {
+	type cache struct {
+		Host string `env:"HOST"`
+		TTL  int    `env:"TTL"`
+	}
+	type config struct {
+		DBHost string `env:"DB_HOST" envGroup:"db"`
+		DBPort int    `env:"DB_PORT" envGroup:"db"`
+		Debug  bool   `env:"DEBUG"`
+		Cache  cache  `envPrefix:"CACHE_" envGroup:"cache"`
+	}
+	os.Setenv("DB_HOST", "db.internal")
+	os.Setenv("DB_PORT", "5432")
+	os.Setenv("DEBUG", "true")
+	os.Setenv("CACHE_HOST", "cache.internal")
+	os.Setenv("CACHE_TTL", "not-a-number")
+	defer os.Clearenv()
+
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Group: "db"}))
+	assert.Equal(t, config{DBHost: "db.internal", DBPort: 5432}, cfg)
+	assert.Equal(t, []string{"DB_HOST", "DB_PORT"}, Keys(&cfg, Options{Group: "db"}))
+
+	assert.Equal(t, []string{"CACHE_HOST", "CACHE_TTL"}, Keys(&cfg, Options{Group: "cache"}))
+	assert.Error(t, ParseWithOptions(&cfg, Options{Group: "cache"}))
+}
+
+func TestMissingPrefixFallback(t *testing.T) {
+	type config struct {
+		Host string `env:"HOST" required:"true"`
+		Port int    `env:"PORT" required:"true"`
+	}
+	os.Setenv("DB_HOST", "db")
+	defer os.Clearenv()
+
+	opts := Options{Prefix: "SERVICE_DB_", PrefixFallback: true}
+	assert.Equal(t, []string{"SERVICE_DB_PORT"}, Missing(&config{}, opts))
+	assert.Equal(t, []string{"SERVICE_DB_HOST", "SERVICE_DB_PORT"}, Missing(&config{}, Options{Prefix: "SERVICE_DB_"}))
+}
+
+func TestMissingSecretRef(t *testing.T) {
+	type config struct {
+		User     string `env:"DB_USER" required:"true"`
+		Password string `env:"DB_PASSWORD" required:"true" envSecretRef:"db/password"`
+	}
+	defer os.Clearenv()
+
+	assert.Equal(t, []string{"DB_USER"}, Missing(&config{}, Options{}))
+}
@@ -0,0 +1,275 @@
+package env
+
+import (
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	imagecolor "image/color"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MarshalEnviron serializes the tagged fields of a struct into a slice of
+// KEY=VALUE strings, in the same form as os.Environ.  The result can be
+// handed directly to APIs such as exec.Cmd.Env.
+func MarshalEnviron(v interface{}) ([]string, error) {
+	envMap, err := dump(v, "", true)
+	if err != nil {
+		return nil, err
+	}
+	environ := make([]string, 0, len(envMap))
+	for key, value := range envMap {
+		environ = append(environ, key+"="+value)
+	}
+	sort.Strings(environ)
+	return environ, nil
+}
+
+// WriteFile writes the tagged fields of a struct to a dotenv file in the
+// format of Marshal, which Load and Read can read back.  Fields tagged
+// envSecret or envSecretRef are written as secretMask unless includeSecrets
+// is set.  The file is written to a temporary file in the same directory
+// first and renamed into place, so readers never see a partial file.
+func WriteFile(path string, v interface{}, includeSecrets bool) error {
+	envMap, err := dump(v, "", includeSecrets)
+	if err != nil {
+		return err
+	}
+	content, err := Marshal(envMap)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(content + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// secretMask replaces the values of secret fields written by WriteFile.
+const secretMask = "********"
+
+// isSecret reports whether a field holds a secret that WriteFile masks.
+func isSecret(field reflect.StructField) bool {
+	return strings.ToLower(field.Tag.Get("envSecret")) == "true" || field.Tag.Get("envSecretRef") != ""
+}
+
+// dump walks a struct (or pointer to a struct) the same way Parse does and
+// returns the current value of every tagged field keyed by its env var name.
+// Unless includeSecrets is set, the values of secret fields are masked.
+func dump(v interface{}, prefix string, includeSecrets bool) (map[string]string, error) {
+	ref := reflect.ValueOf(v)
+	if ref.Kind() == reflect.Ptr {
+		ref = ref.Elem()
+	}
+	if ref.Kind() != reflect.Struct {
+		return nil, ErrNotAStructPtr
+	}
+	envMap := make(map[string]string)
+	if err := doDump(ref, prefix, envMap, includeSecrets); err != nil {
+		return nil, err
+	}
+	return envMap, nil
+}
+
+func doDump(ref reflect.Value, prefix string, envMap map[string]string, includeSecrets bool) error {
+	refType := ref.Type()
+	for i := 0; i < refType.NumField(); i++ {
+		refField := ref.Field(i)
+		refTypeField := refType.Field(i)
+		if refTypeField.PkgPath != "" && !refTypeField.Anonymous {
+			continue
+		}
+		fieldPrefix := prefix + refTypeField.Tag.Get("envPrefix")
+		key, hasTag := refTypeField.Tag.Lookup("env")
+		if !hasTag {
+			if reflect.Ptr == refField.Kind() && !refField.IsNil() && reflect.Struct == refField.Elem().Kind() {
+				refField = refField.Elem()
+			}
+			if reflect.Struct == refField.Kind() {
+				if err := doDump(refField, fieldPrefix, envMap, includeSecrets); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if reflect.Ptr == refField.Kind() && refField.IsNil() {
+			continue
+		}
+		if !includeSecrets && isSecret(refTypeField) {
+			envMap[fieldPrefix+key] = secretMask
+			continue
+		}
+		value, err := formatValue(refField, refTypeField)
+		if err != nil {
+			return fmt.Errorf("%s: %v", refTypeField.Name, err)
+		}
+		envMap[fieldPrefix+key] = value
+	}
+	return nil
+}
+
+// formatValue is the inverse of set: it renders a field value as the string
+// that set would parse back into the same value.
+func formatValue(field reflect.Value, refType reflect.StructField) (string, error) {
+	if strings.ToLower(refType.Tag.Get("envJSON")) == "true" {
+		data, err := json.Marshal(field.Interface())
+		return string(data), err
+	}
+
+	if lazy, ok := field.Interface().(func() string); ok && strings.ToLower(refType.Tag.Get("envLazy")) == "true" {
+		if lazy == nil {
+			return "", nil
+		}
+		return lazy(), nil
+	}
+
+	if tm, ok := textMarshaler(field); ok {
+		text, err := tm.MarshalText()
+		return string(text), err
+	}
+
+	if runes, ok := field.Interface().([]rune); ok && strings.ToLower(refType.Tag.Get("envRunes")) == "true" {
+		return string(runes), nil
+	}
+
+	if refType.Type == reflect.TypeOf(url.URL{}) {
+		u := field.Interface().(url.URL)
+		return u.String(), nil
+	}
+
+	if loc, ok := field.Interface().(*time.Location); ok {
+		if loc == nil {
+			return "", nil
+		}
+		return loc.String(), nil
+	}
+
+	if _, ok := rangeType(field.Type()); ok && strings.ToLower(refType.Tag.Get("envRange")) == "true" {
+		lo, err := formatValue(field.FieldByName("Min"), reflect.StructField{Type: field.FieldByName("Min").Type()})
+		if err != nil {
+			return "", err
+		}
+		hi, err := formatValue(field.FieldByName("Max"), reflect.StructField{Type: field.FieldByName("Max").Type()})
+		if err != nil {
+			return "", err
+		}
+		return lo + ".." + hi, nil
+	}
+
+	if isUUIDType(field.Type()) && strings.ToLower(refType.Tag.Get("envFormat")) == "uuid" {
+		id := make([]byte, 16)
+		reflect.Copy(reflect.ValueOf(id), field)
+		digits := hex.EncodeToString(id)
+		return digits[0:8] + "-" + digits[8:12] + "-" + digits[12:16] + "-" + digits[16:20] + "-" + digits[20:], nil
+	}
+
+	if ipNet, ok := field.Interface().(net.IPNet); ok {
+		return ipNet.String(), nil
+	}
+
+	if addr, ok := field.Interface().(mail.Address); ok {
+		return addr.String(), nil
+	}
+
+	if c, ok := field.Interface().(imagecolor.RGBA); ok {
+		if c.A == 0xff {
+			return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B), nil
+		}
+		return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A), nil
+	}
+
+	switch field.Kind() {
+	case reflect.Slice:
+		separator := tagSeparator(refType.Tag)
+		if separator == "auto" {
+			separator = ","
+		}
+		parts := make([]string, 0, field.Len())
+		for i := 0; i < field.Len(); i++ {
+			part, err := formatValue(field.Index(i), reflect.StructField{Type: field.Type().Elem(), Tag: refType.Tag})
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, part)
+		}
+		return strings.Join(parts, separator), nil
+	case reflect.Ptr, reflect.Interface:
+		if field.IsNil() {
+			return "", nil
+		}
+		return formatValue(field.Elem(), reflect.StructField{Type: field.Elem().Type(), Tag: refType.Tag})
+	case reflect.String:
+		return field.String(), nil
+	case reflect.Bool:
+		if word := refType.Tag.Get("envTrue"); word != "" && field.Bool() {
+			return word, nil
+		}
+		if word := refType.Tag.Get("envFalse"); word != "" && !field.Bool() {
+			return word, nil
+		}
+		if strings.ToLower(refType.Tag.Get("envBool")) == "numeric" {
+			if field.Bool() {
+				return "1", nil
+			}
+			return "0", nil
+		}
+		return strconv.FormatBool(field.Bool()), nil
+	case reflect.Int, reflect.Int64:
+		if field.Type() == reflect.TypeOf(time.Duration(0)) {
+			return time.Duration(field.Int()).String(), nil
+		}
+		return strconv.FormatInt(field.Int(), 10), nil
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(field.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(field.Float(), 'g', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(field.Float(), 'g', -1, 64), nil
+	}
+	return "", ErrUnsupportedType
+}
+
+// textMarshaler returns the encoding.TextMarshaler implemented by the value
+// or, when addressable, by a pointer to it.
+func textMarshaler(field reflect.Value) (encoding.TextMarshaler, bool) {
+	if field.Kind() != reflect.Ptr && field.CanAddr() {
+		if tm, ok := field.Addr().Interface().(encoding.TextMarshaler); ok {
+			return tm, true
+		}
+	}
+	if field.Kind() == reflect.Ptr && field.IsNil() {
+		return nil, false
+	}
+	if field.CanInterface() {
+		if tm, ok := field.Interface().(encoding.TextMarshaler); ok {
+			return tm, true
+		}
+	}
+	if field.Kind() != reflect.Ptr && field.CanInterface() {
+		// a value read from a struct passed by value is not addressable, so
+		// pointer methods such as (*big.Rat).MarshalText need a copy
+		ptr := reflect.New(field.Type())
+		ptr.Elem().Set(field)
+		tm, ok := ptr.Interface().(encoding.TextMarshaler)
+		return tm, ok
+	}
+	return nil, false
+}
@@ -0,0 +1,280 @@
+package env
+
+import (
+	imagecolor "image/color"
+	"math/big"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type marshalConfig struct {
+	Name     string          `env:"NAME"`
+	Port     int             `env:"PORT"`
+	Debug    bool            `env:"DEBUG"`
+	Ratio    float64         `env:"RATIO"`
+	Timeout  time.Duration   `env:"TIMEOUT"`
+	Endpoint url.URL         `env:"ENDPOINT"`
+	Tags     []string        `env:"TAGS" envSeparator:":"`
+	Waits    []time.Duration `env:"WAITS"`
+	NotAnEnv string
+}
+
+func TestMarshalEnviron(t *testing.T) {
+	u, _ := url.Parse("https://example.com/path?q=1")
+	cfg := marshalConfig{
+		Name:     "svc",
+		Port:     8080,
+		Debug:    true,
+		Ratio:    0.25,
+		Timeout:  90 * time.Second,
+		Endpoint: *u,
+		Tags:     []string{"a", "b"},
+		Waits:    []time.Duration{time.Second, time.Minute},
+		NotAnEnv: "ignored",
+	}
+
+	environ, err := MarshalEnviron(&cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"DEBUG=true",
+		"ENDPOINT=https://example.com/path?q=1",
+		"NAME=svc",
+		"PORT=8080",
+		"RATIO=0.25",
+		"TAGS=a:b",
+		"TIMEOUT=1m30s",
+		"WAITS=1s,1m0s",
+	}, environ)
+}
+
+func TestMarshalEnvironNotAStruct(t *testing.T) {
+	_, err := MarshalEnviron(42)
+	assert.Equal(t, ErrNotAStructPtr, err)
+}
+
+func TestMarshalEnvironUnsupportedType(t *testing.T) {
+	type config struct {
+		Values map[string]string `env:"VALUES"`
+	}
+	_, err := MarshalEnviron(config{Values: map[string]string{"a": "b"}})
+	assert.Error(t, err)
+}
+
+func TestMarshalEnvironRoundtrip(t *testing.T) {
+	envPath, err := exec.LookPath("env")
+	if err != nil {
+		t.Skip("env command not available")
+	}
+
+	u, _ := url.Parse("http://localhost:9000")
+	cfg := marshalConfig{
+		Name:     "roundtrip",
+		Port:     9000,
+		Ratio:    1.5,
+		Timeout:  time.Hour,
+		Endpoint: *u,
+		Tags:     []string{"x", "y", "z"},
+	}
+	environ, err := MarshalEnviron(cfg)
+	assert.NoError(t, err)
+
+	cmd := exec.Command(envPath)
+	cmd.Env = environ
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+
+	envMap, err := Unmarshal(string(out))
+	assert.NoError(t, err)
+
+	os.Clearenv()
+	defer os.Clearenv()
+	for key, value := range envMap {
+		os.Setenv(key, value)
+	}
+
+	parsed := marshalConfig{}
+	assert.NoError(t, Parse(&parsed))
+	assert.Equal(t, cfg, parsed)
+}
+
+func TestMarshalEnvironNumericBool(t *testing.T) {
+	type config struct {
+		Enabled bool   `env:"ENABLED" envBool:"numeric"`
+		Flags   []bool `env:"FLAGS" envBool:"numeric"`
+	}
+	environ, err := MarshalEnviron(config{Enabled: true, Flags: []bool{false, true}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ENABLED=1", "FLAGS=0,1"}, environ)
+}
+
+func TestMarshalEnvironLocation(t *testing.T) {
+	type config struct {
+		Zone  *time.Location `env:"ZONE"`
+		Unset *time.Location `env:"UNSET"`
+	}
+	environ, err := MarshalEnviron(config{Zone: time.UTC})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ZONE=UTC"}, environ)
+}
+
+func TestMarshalEnvironMultiCharacterSeparator(t *testing.T) {
+	type config struct {
+		Pipes []string `env:"PIPES" envSeparator:"||"`
+	}
+	environ, err := MarshalEnviron(config{Pipes: []string{"a|b", "c"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"PIPES=a|b||c"}, environ)
+}
+
+func TestMarshalEnvironCustomBoolWords(t *testing.T) {
+	type config struct {
+		On  bool `env:"ON" envTrue:"enabled" envFalse:"disabled"`
+		Off bool `env:"OFF" envTrue:"enabled" envFalse:"disabled"`
+	}
+	environ, err := MarshalEnviron(config{On: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"OFF=disabled", "ON=enabled"}, environ)
+}
+
+func TestMarshalEnvironJSON(t *testing.T) {
+	type config struct {
+		Servers map[string]ServerConfig `env:"SERVERS" envJSON:"true"`
+	}
+	environ, err := MarshalEnviron(config{Servers: map[string]ServerConfig{"web": {Host: "w", Port: 1}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`SERVERS={"web":{"Host":"w","Port":1}}`}, environ)
+}
+
+func TestMarshalEnvironRunes(t *testing.T) {
+	type config struct {
+		Symbols []rune `env:"SYMBOLS" envRunes:"true"`
+	}
+	environ, err := MarshalEnviron(config{Symbols: []rune("é,€")})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SYMBOLS=é,€"}, environ)
+}
+
+func TestMarshalEnvironFieldPrefix(t *testing.T) {
+	type endpoint struct {
+		Host string `env:"HOST"`
+	}
+	type config struct {
+		DBHost string   `env:"HOST" envPrefix:"DB_"`
+		Admin  endpoint `envPrefix:"ADMIN_"`
+	}
+	environ, err := MarshalEnviron(config{DBHost: "db", Admin: endpoint{Host: "admin"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ADMIN_HOST=admin", "DB_HOST=db"}, environ)
+}
+
+func TestMarshalEnvironInfer(t *testing.T) {
+	type config struct {
+		Count interface{} `env:"COUNT" envInfer:"true"`
+		Unset interface{} `env:"UNSET" envInfer:"true"`
+	}
+	environ, err := MarshalEnviron(config{Count: 42})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"COUNT=42", "UNSET="}, environ)
+}
+
+func TestWriteFile(t *testing.T) {
+	type config struct {
+		Host     string `env:"HOST"`
+		Port     int    `env:"PORT"`
+		Password string `env:"PASSWORD" envSecret:"true"`
+	}
+	cfg := config{Host: "db.internal", Port: 5432, Password: "s3cret"}
+	path := filepath.Join(t.TempDir(), ".env")
+
+	assert.NoError(t, WriteFile(path, &cfg, false))
+	envMap, err := Read(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"HOST": "db.internal", "PORT": "5432", "PASSWORD": secretMask}, envMap)
+
+	assert.NoError(t, WriteFile(path, &cfg, true))
+	os.Clearenv()
+	defer os.Clearenv()
+	assert.NoError(t, Load(path))
+	parsed := config{}
+	assert.NoError(t, Parse(&parsed))
+	assert.Equal(t, cfg, parsed)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestMarshalEnvironBigRat(t *testing.T) {
+	type config struct {
+		Tax big.Rat `env:"TAX"`
+	}
+	cfg := config{}
+	cfg.Tax.SetFrac64(3, 7)
+	environ, err := MarshalEnviron(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"TAX=3/7"}, environ)
+}
+
+func TestMarshalEnvironHexColor(t *testing.T) {
+	type config struct {
+		Background imagecolor.RGBA `env:"BACKGROUND"`
+		Overlay    imagecolor.RGBA `env:"OVERLAY"`
+	}
+	environ, err := MarshalEnviron(config{Background: imagecolor.RGBA{R: 0x1e, G: 0x90, B: 0xff, A: 0xff}, Overlay: imagecolor.RGBA{A: 0x80}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"BACKGROUND=#1e90ff", "OVERLAY=#00000080"}, environ)
+}
+
+func TestMarshalEnvironRange(t *testing.T) {
+	type durationRange struct {
+		Min, Max time.Duration
+	}
+	type config struct {
+		Backoff durationRange `env:"RETRY_BACKOFF" envRange:"true"`
+	}
+	environ, err := MarshalEnviron(config{Backoff: durationRange{Min: time.Second, Max: 30 * time.Second}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"RETRY_BACKOFF=1s..30s"}, environ)
+}
+
+func TestMarshalEnvironMailAddress(t *testing.T) {
+	type config struct {
+		From mail.Address   `env:"FROM"`
+		To   []mail.Address `env:"TO" envSeparator:";"`
+	}
+	environ, err := MarshalEnviron(config{
+		From: mail.Address{Name: "Alerts", Address: "alerts@example.com"},
+		To:   []mail.Address{{Address: "bob@example.com"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`FROM="Alerts" <alerts@example.com>`, "TO=<bob@example.com>"}, environ)
+}
+
+func TestMarshalEnvironUUID(t *testing.T) {
+	type config struct {
+		Tenant [16]byte `env:"TENANT" envFormat:"uuid"`
+	}
+	id, _ := parseUUID("123e4567-e89b-12d3-a456-426614174000")
+	environ, err := MarshalEnviron(config{Tenant: id})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"TENANT=123e4567-e89b-12d3-a456-426614174000"}, environ)
+}
+
+func TestMarshalEnvironIPNets(t *testing.T) {
+	type config struct {
+		Allow []net.IPNet `env:"ALLOW"`
+	}
+	_, a, _ := net.ParseCIDR("10.0.0.0/8")
+	_, b, _ := net.ParseCIDR("2001:db8::/32")
+	environ, err := MarshalEnviron(config{Allow: []net.IPNet{*a, *b}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ALLOW=10.0.0.0/8,2001:db8::/32"}, environ)
+}
@@ -0,0 +1,65 @@
+package env
+
+import (
+	"os"
+	"strings"
+)
+
+// Source supplies values for env var keys.  Options.Sources are consulted in
+// order before the process environment.
+type Source interface {
+	// Lookup returns the value for key and whether it was present.
+	Lookup(key string) (string, bool)
+}
+
+// KeySource is a Source that can also list the keys it has.  Slices tagged
+// envIndexed find their elements only in the environment and in the sources
+// that implement it, since their keys cannot be known in advance.
+type KeySource interface {
+	Source
+	// Keys returns every key the source has, in no particular order.
+	Keys() []string
+}
+
+// MapSource is a Source backed by a map, for example values parsed from
+// flags or read from a file.
+type MapSource map[string]string
+
+// Lookup returns the value stored for key.
+func (m MapSource) Lookup(key string) (string, bool) {
+	value, ok := m[key]
+	return value, ok
+}
+
+// Keys returns the keys of the map.
+func (m MapSource) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// OSSource is a Source that reads the process environment.  It is useful to
+// place the environment ahead of other sources in Options.Sources.
+type OSSource struct{}
+
+// Lookup returns the value of the env var named key.
+func (OSSource) Lookup(key string) (string, bool) {
+	return os.LookupEnv(key)
+}
+
+// Keys returns the names of the env vars.
+func (OSSource) Keys() []string {
+	return environKeys()
+}
+
+// environKeys returns the names of the variables in the process environment.
+func environKeys() []string {
+	environ := os.Environ()
+	keys := make([]string, 0, len(environ))
+	for _, rawEnvLine := range environ {
+		keys = append(keys, strings.SplitN(rawEnvLine, "=", 2)[0])
+	}
+	return keys
+}
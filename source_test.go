@@ -0,0 +1,54 @@
+package env
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSources(t *testing.T) {
+	type config struct {
+		Host  string `env:"HOST"`
+		Port  int    `env:"PORT" required:"true"`
+		Debug bool   `env:"DEBUG" envDefault:"true"`
+	}
+	os.Setenv("APP_HOST", "os-host")
+	os.Setenv("APP_PORT", "80")
+	defer os.Clearenv()
+
+	overrides := MapSource{"APP_HOST": "map-host", "APP_PORT": "8080"}
+	cfg := config{}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Prefix: "APP_", Sources: []Source{overrides}}))
+	assert.Equal(t, config{Host: "map-host", Port: 8080, Debug: true}, cfg)
+
+	cfg = config{}
+	opts := Options{Prefix: "APP_", Sources: []Source{OSSource{}, overrides}}
+	assert.NoError(t, ParseWithOptions(&cfg, opts))
+	assert.Equal(t, config{Host: "os-host", Port: 80, Debug: true}, cfg)
+
+	os.Unsetenv("APP_PORT")
+	assert.Empty(t, Missing(&cfg, Options{Prefix: "APP_", Sources: []Source{overrides}}))
+	assert.Equal(t, []string{"APP_PORT"}, Missing(&cfg, Options{Prefix: "APP_"}))
+}
+
+func TestSourcesIndexed(t *testing.T) {
+	os.Setenv("SERVERS_1_HOST", "env")
+	defer os.Clearenv()
+
+	cfg := indexedConfig{}
+	source := MapSource{"SERVERS_0_HOST": "alpha", "SERVERS_2_HOST": "gamma", "SERVERS_2_PORT": "8080"}
+	assert.NoError(t, ParseWithOptions(&cfg, Options{Sources: []Source{source}}))
+	assert.Equal(t, []ServerConfig{
+		{Host: "alpha", Port: 80},
+		{Host: "env", Port: 80},
+		{Host: "gamma", Port: 8080},
+	}, cfg.Servers)
+
+	os.Clearenv()
+	os.Setenv("LEGACY_SERVERS_0_HOST", "legacy")
+	cfg = indexedConfig{}
+	rewrite := func(key string) string { return "LEGACY_" + key }
+	assert.NoError(t, ParseWithOptions(&cfg, Options{KeyRewrite: rewrite}))
+	assert.Equal(t, []ServerConfig{{Host: "legacy", Port: 80}}, cfg.Servers)
+}
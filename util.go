@@ -1,10 +1,12 @@
 package env
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,6 +20,51 @@ func Unset(key string) error {
 	return os.Unsetenv(key)
 }
 
+// Snapshot - captures the current environment and returns a function that
+// restores it, e.g. defer env.Snapshot()().  The restore function keeps
+// going past a failed os.Setenv and returns all such errors joined.
+func Snapshot() func() error {
+	saved := EnvironMap()
+	return func() error {
+		os.Clearenv()
+		var errs []error
+		for key, value := range saved {
+			if err := os.Setenv(key, value); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// EnvironMap - returns the current environment as a map of keys to values
+func EnvironMap() map[string]string {
+	envMap := make(map[string]string)
+	for _, rawEnvLine := range os.Environ() {
+		kv := strings.SplitN(rawEnvLine, "=", 2)
+		if len(kv) == 2 {
+			envMap[kv[0]] = kv[1]
+		}
+	}
+	return envMap
+}
+
+// KeysWithPrefix - returns the environment variables whose names start with
+// prefix, with the prefix removed from the keys if strip is true
+func KeysWithPrefix(prefix string, strip bool) map[string]string {
+	matches := make(map[string]string)
+	for key, value := range EnvironMap() {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if strip {
+			key = strings.TrimPrefix(key, prefix)
+		}
+		matches[key] = value
+	}
+	return matches
+}
+
 // Get - get an environment variable, empty string if does not exist
 func Get(key string) string {
 	return os.Getenv(key)
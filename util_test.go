@@ -269,3 +269,48 @@ func TestUrlFuncs(t *testing.T) {
 	assert.Equal(t, "google.com", GetOrUrl("BAD_URL", "http://google.com").Hostname())
 	assert.Panics(t, func() { MustGetUrl("BAD_URL") }, "The code did not panic")
 }
+
+func TestSnapshot(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("SNAP_KEPT", "original")
+	os.Setenv("SNAP_CHANGED", "before")
+	os.Setenv("SNAP_EQUALS", "a=b")
+
+	restore := Snapshot()
+	os.Setenv("SNAP_CHANGED", "after")
+	os.Setenv("SNAP_ADDED", "new")
+	os.Unsetenv("SNAP_KEPT")
+	assert.Equal(t, "after", Get("SNAP_CHANGED"))
+	assert.NoError(t, restore())
+
+	assert.Equal(t, "original", Get("SNAP_KEPT"))
+	assert.Equal(t, "before", Get("SNAP_CHANGED"))
+	assert.Equal(t, "a=b", Get("SNAP_EQUALS"))
+	_, added := os.LookupEnv("SNAP_ADDED")
+	assert.False(t, added)
+}
+
+func TestEnvironMap(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("MAP_ONE", "1")
+	os.Setenv("MAP_EQUALS", "a=b")
+	os.Setenv("MAP_EMPTY", "")
+
+	assert.Equal(t, map[string]string{
+		"MAP_ONE":    "1",
+		"MAP_EQUALS": "a=b",
+		"MAP_EMPTY":  "",
+	}, EnvironMap())
+}
+
+func TestKeysWithPrefix(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("APP_HOST", "localhost")
+	os.Setenv("APP_PORT", "8080")
+	os.Setenv("OTHER_HOST", "remote")
+	os.Setenv("XAPP_HOST", "nope")
+
+	assert.Equal(t, map[string]string{"APP_HOST": "localhost", "APP_PORT": "8080"}, KeysWithPrefix("APP_", false))
+	assert.Equal(t, map[string]string{"HOST": "localhost", "PORT": "8080"}, KeysWithPrefix("APP_", true))
+	assert.Empty(t, KeysWithPrefix("MISSING_", true))
+}